@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodeLabel is the label of the node name on which the rotation runs
+const NodeLabel = "caasp.suse.com/kucero-node"
+
+// RotationOutcome is the final outcome of a certificate rotation
+type RotationOutcome string
+
+const (
+	RotationRunning   RotationOutcome = "Running"
+	RotationSucceeded RotationOutcome = "Succeeded"
+	RotationFailed    RotationOutcome = "Failed"
+)
+
+// CertificateRotationSpec defines what the certificate rotation is going to do
+type CertificateRotationSpec struct {
+	// NodeName is the node on which the rotation runs
+	NodeName string `json:"nodeName"`
+
+	// ControlPlane is true if the node is a control plane node
+	ControlPlane bool `json:"controlPlane,omitempty"`
+
+	// Certificates are the certificates to be renewed
+	// +optional
+	Certificates []string `json:"certificates,omitempty"`
+
+	// Configs are the kubelet configuration files to be updated
+	// +optional
+	Configs []string `json:"configs,omitempty"`
+}
+
+// PhaseStatus records a phase of the certificate rotation
+type PhaseStatus struct {
+	// Name is the phase name, e.g. cordon, drain, update-config, renew, uncordon
+	Name string `json:"name"`
+
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Error is the error message if the phase failed
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// CertificateRotationStatus records the progress and the outcome of the certificate rotation
+type CertificateRotationStatus struct {
+	// Outcome is the rotation outcome, one of Running, Succeeded or Failed
+	Outcome RotationOutcome `json:"outcome,omitempty"`
+
+	// Backups are the backup files taken before renewing the certificates
+	// +optional
+	Backups []string `json:"backups,omitempty"`
+
+	// Phases are the rotation phases in order
+	// +optional
+	Phases []PhaseStatus `json:"phases,omitempty"`
+
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Error is the error message if the rotation failed
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=certrot
+// +kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.spec.nodeName`
+// +kubebuilder:printcolumn:name="Outcome",type=string,JSONPath=`.status.outcome`
+// +kubebuilder:printcolumn:name="Started",type=date,JSONPath=`.status.startTime`
+// +kubebuilder:printcolumn:name="Completed",type=date,JSONPath=`.status.completionTime`
+
+// CertificateRotation records a certificate rotation run on a node
+type CertificateRotation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CertificateRotationSpec   `json:"spec,omitempty"`
+	Status CertificateRotationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// CertificateRotationList contains a list of CertificateRotation
+type CertificateRotationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CertificateRotation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CertificateRotation{}, &CertificateRotationList{})
+}
@@ -0,0 +1,463 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/lock"
+	"github.com/jenting/kucero/pkg/pki/cert"
+	"github.com/jenting/kucero/pkg/pki/cert/kubeadm"
+)
+
+const (
+	// caRotationAnnotation records the CA rotation phase completed by the node, `<id>/<phase>`
+	caRotationAnnotation = "caasp.suse.com/kucero-ca-rotation"
+
+	// the CA rotation ConfigMap keys
+	caRotationAuthorityKey = "authority"
+	caRotationPhaseKey     = "phase"
+	caRotationIDKey        = "id"
+	// caRotationCertificateKey holds the new CA certificate or service account public key
+	caRotationCertificateKey = "certificate"
+	// caRotationReissuedAtKey records when every node completed the reissue phase
+	caRotationReissuedAtKey = "reissuedAt"
+
+	// caRotationPrivateKeyKey is the Secret key holding the new private key,
+	// read by the control plane nodes in the reissue phase only
+	caRotationPrivateKeyKey = "key"
+)
+
+// caRotationName returns the name of the ConfigMap driving the CA rotation
+// and of the Secret holding the new private key
+func caRotationName() string {
+	return lockName + "-ca-rotation"
+}
+
+// rotateCertificateAuthority runs the current CA rotation phase on the node,
+// a control plane node starts the rotation if one of its CAs is going to expire
+// or its service account signing key is older than --service-account-key-max-age
+func rotateCertificateAuthority(client *kubernetes.Clientset, nodeName string, isControlPlaneNode bool, caRotation *kubeadm.CARotation, rotationLock *lock.Semaphore, plan *rotationPlan, expiryPolicy cert.ExpiryPolicy) {
+	cm, err := client.CoreV1().ConfigMaps(dsNamespace).Get(context.TODO(), caRotationName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = nil
+	} else if err != nil {
+		logrus.Errorf("Error getting CA rotation ConfigMap %s/%s: %v", dsNamespace, caRotationName(), err)
+		return
+	}
+
+	phase := kubeadm.CAPhaseDone
+	if cm != nil {
+		phase = cm.Data[caRotationPhaseKey]
+	}
+	if phase == kubeadm.CAPhaseDone {
+		if isControlPlaneNode {
+			startCARotation(client, cm, caRotation, plan, expiryPolicy)
+		}
+		return
+	}
+
+	authority := cm.Data[caRotationAuthorityKey]
+	if err := kubeadm.ValidateCAPhase(phase); err != nil {
+		logrus.Errorf("Error in CA rotation ConfigMap %s/%s: %v", dsNamespace, caRotationName(), err)
+		return
+	}
+	if err := kubeadm.ValidateRotation(authority); err != nil {
+		logrus.Errorf("Error in CA rotation ConfigMap %s/%s: %v", dsNamespace, caRotationName(), err)
+		return
+	}
+
+	if phase == kubeadm.CAPhasePrepare {
+		if isControlPlaneNode {
+			prepareCARotation(client, cm, caRotation, rotationLock)
+		}
+		return
+	}
+	runCARotationPhase(client, nodeName, isControlPlaneNode, cm, caRotation, rotationLock)
+}
+
+// startCARotation starts the rotation of the first CA going to expire,
+// otherwise of the service account signing key if it is too old
+func startCARotation(client *kubernetes.Clientset, cm *corev1.ConfigMap, caRotation *kubeadm.CARotation, plan *rotationPlan, expiryPolicy cert.ExpiryPolicy) {
+	authority := ""
+	now := time.Now()
+	for _, info := range plan.infos {
+		if info.IsCA && expiryPolicy.CheckExpiry(info, now) {
+			authority = info.Name
+			logrus.Infof("The CA %s expires at %v, starting the CA rotation", authority, info.NotAfter)
+			break
+		}
+	}
+	if authority == "" && serviceAccountKeyMaxAge > 0 {
+		modTime, err := caRotation.ServiceAccountKeyModTime()
+		if err != nil {
+			logrus.Errorf("Error checking the service account signing key age: %v", err)
+		} else if now.Sub(modTime) > serviceAccountKeyMaxAge {
+			authority = kubeadm.ServiceAccountKey
+			logrus.Infof("The service account signing key is written at %v, starting the service account key rotation", modTime)
+		}
+	}
+	if authority == "" {
+		return
+	}
+
+	// the new CA of a previous rotation must not be reused
+	err := client.CoreV1().Secrets(dsNamespace).Delete(context.TODO(), caRotationName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logrus.Errorf("Error deleting CA rotation Secret %s/%s: %v", dsNamespace, caRotationName(), err)
+		return
+	}
+
+	data := map[string]string{
+		caRotationAuthorityKey: authority,
+		caRotationPhaseKey:     kubeadm.CAPhasePrepare,
+	}
+	if cm == nil {
+		_, err = client.CoreV1().ConfigMaps(dsNamespace).Create(context.TODO(), &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: caRotationName(), Namespace: dsNamespace},
+			Data:       data,
+		}, metav1.CreateOptions{})
+	} else {
+		cm.Data = data
+		_, err = client.CoreV1().ConfigMaps(dsNamespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+	}
+	switch {
+	case apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err):
+		logrus.Info("The CA rotation is started by another node")
+	case err != nil:
+		logrus.Errorf("Error starting the CA rotation: %v", err)
+	}
+}
+
+// prepareCARotation generates the new CA, shares the certificate in the ConfigMap
+// and the private key in the Secret, then advances the rotation to the trust phase
+func prepareCARotation(client *kubernetes.Clientset, cm *corev1.ConfigMap, caRotation *kubeadm.CARotation, rotationLock *lock.Semaphore) {
+	authority := cm.Data[caRotationAuthorityKey]
+
+	rotationID := utilrand.String(5)
+	if !acquire(rotationLock, rotationID) {
+		return
+	}
+	defer release(rotationLock)
+
+	// another control plane node may have prepared the rotation while waiting for the lock
+	cm, err := client.CoreV1().ConfigMaps(dsNamespace).Get(context.TODO(), caRotationName(), metav1.GetOptions{})
+	if err != nil {
+		logrus.Errorf("Error getting CA rotation ConfigMap %s/%s: %v", dsNamespace, caRotationName(), err)
+		return
+	}
+	if cm.Data[caRotationPhaseKey] != kubeadm.CAPhasePrepare || cm.Data[caRotationAuthorityKey] != authority {
+		return
+	}
+
+	certPEM, keyPEM, err := caRotation.Prepare(authority)
+	if err != nil {
+		logrus.Errorf("Error generating the new CA %s: %v", authority, err)
+		return
+	}
+	// the private key of a failed prepare attempt does not match the new certificate
+	err = client.CoreV1().Secrets(dsNamespace).Delete(context.TODO(), caRotationName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logrus.Errorf("Error deleting CA rotation Secret %s/%s: %v", dsNamespace, caRotationName(), err)
+		return
+	}
+	_, err = client.CoreV1().Secrets(dsNamespace).Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: caRotationName(), Namespace: dsNamespace},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{caRotationPrivateKeyKey: keyPEM},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		logrus.Errorf("Error creating CA rotation Secret %s/%s: %v", dsNamespace, caRotationName(), err)
+		return
+	}
+
+	cm.Data[caRotationPhaseKey] = kubeadm.CAPhaseTrust
+	cm.Data[caRotationIDKey] = rotationID
+	cm.Data[caRotationCertificateKey] = string(certPEM)
+	if _, err := client.CoreV1().ConfigMaps(dsNamespace).Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+		logrus.Errorf("Error advancing the CA rotation: %v", err)
+		return
+	}
+	logrus.Infof("The new CA %s is generated, advancing the CA rotation to the %s phase", authority, kubeadm.CAPhaseTrust)
+}
+
+// runCARotationPhase runs the CA rotation phase on the node under the rotation lock,
+// after the phases the node missed, the worker nodes wait for the control plane nodes to complete the phase,
+// then a control plane node advances the rotation under the rotation lock
+// once every node kucero runs on completed the phase
+func runCARotationPhase(client *kubernetes.Clientset, nodeName string, isControlPlaneNode bool, cm *corev1.ConfigMap, caRotation *kubeadm.CARotation, rotationLock *lock.Semaphore) {
+	authority := cm.Data[caRotationAuthorityKey]
+	phase := cm.Data[caRotationPhaseKey]
+	completed := cm.Data[caRotationIDKey] + "/" + phase
+
+	nodes, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		logrus.Errorf("Error listing nodes: %v", err)
+		return
+	}
+	running, err := kuceroNodes(client)
+	if err != nil {
+		logrus.Errorf("Error listing the kucero DaemonSet %s/%s pods: %v", dsNamespace, dsName, err)
+		return
+	}
+
+	for _, n := range nodes.Items {
+		if n.GetName() != nodeName || n.GetAnnotations()[caRotationAnnotation] == completed {
+			continue
+		}
+
+		// a node missing the previous phases, e.g. down while they ran, replays them first
+		for _, p := range missedCAPhases(n.GetAnnotations()[caRotationAnnotation], cm.Data[caRotationIDKey], phase) {
+			if !runCARotationNodePhase(client, nodeName, isControlPlaneNode, nodes.Items, running, cm, p, caRotation, rotationLock) {
+				return
+			}
+		}
+		return
+	}
+
+	// the control plane nodes advance the rotation, only their identity reads and deletes the private key Secret
+	if !isControlPlaneNode || !caRotationCompleted(nodes.Items, running, completed, false) {
+		return
+	}
+	if !acquire(rotationLock, cm.Data[caRotationIDKey]) {
+		return
+	}
+	defer release(rotationLock)
+
+	// another control plane node may have advanced the rotation while waiting for the lock
+	cm, err = client.CoreV1().ConfigMaps(dsNamespace).Get(context.TODO(), caRotationName(), metav1.GetOptions{})
+	if err != nil {
+		logrus.Errorf("Error getting CA rotation ConfigMap %s/%s: %v", dsNamespace, caRotationName(), err)
+		return
+	}
+	if cm.Data[caRotationPhaseKey] != phase || cm.Data[caRotationIDKey]+"/"+phase != completed {
+		return
+	}
+
+	next := kubeadm.NextCAPhase(phase)
+	// every control plane node signs with the new private key once the reissue phase is completed
+	if phase == kubeadm.CAPhaseReissue {
+		err := client.CoreV1().Secrets(dsNamespace).Delete(context.TODO(), caRotationName(), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			logrus.Errorf("Error deleting CA rotation Secret %s/%s: %v", dsNamespace, caRotationName(), err)
+			return
+		}
+	}
+	// the legacy token Secrets signed by the old service account key are rejected once it is dropped
+	if authority == kubeadm.ServiceAccountKey && phase == kubeadm.CAPhaseReissue {
+		if err := regenerateServiceAccountTokens(client, cm.Data[caRotationIDKey]); err != nil {
+			logrus.Errorf("Error regenerating the service account token Secrets, retrying at the next polling period: %v", err)
+			return
+		}
+	}
+	cm.Data[caRotationPhaseKey] = next
+	if next == kubeadm.CAPhaseFinalize {
+		cm.Data[caRotationReissuedAtKey] = time.Now().UTC().Format(time.RFC3339)
+	}
+	if _, err := client.CoreV1().ConfigMaps(dsNamespace).Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil && !apierrors.IsConflict(err) {
+		logrus.Errorf("Error advancing the CA rotation: %v", err)
+		return
+	}
+	logrus.Infof("Every node completed the CA %s rotation phase %s, advancing to the %s phase", authority, phase, next)
+}
+
+// regenerateServiceAccountTokens removes the token of the legacy service account token Secrets,
+// kube-controller-manager signs a new token with the new service account key,
+// the Secrets are annotated with the rotation ID to be regenerated once,
+// run by the control plane node advancing the rotation, only its identity lists and updates the Secrets
+func regenerateServiceAccountTokens(client kubernetes.Interface, rotationID string) error {
+	secrets, err := client.CoreV1().Secrets(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("type", string(corev1.SecretTypeServiceAccountToken)).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if secret.Type != corev1.SecretTypeServiceAccountToken || secret.GetAnnotations()[caRotationAnnotation] == rotationID {
+			continue
+		}
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[caRotationAnnotation] = rotationID
+		delete(secret.Data, corev1.ServiceAccountTokenKey)
+		if _, err := client.CoreV1().Secrets(secret.GetNamespace()).Update(context.TODO(), secret, metav1.UpdateOptions{}); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to regenerate service account token Secret %s/%s: %w", secret.GetNamespace(), secret.GetName(), err))
+			continue
+		}
+		logrus.Infof("The service account token Secret %s/%s is regenerated with the new service account key", secret.GetNamespace(), secret.GetName())
+	}
+	return errs
+}
+
+// runCARotationNodePhase runs the CA rotation phase `phase` on the node under the rotation lock
+// and records it in the node annotation, the phase is the current phase or a phase the node missed
+// returns true if the phase is completed on the node
+func runCARotationNodePhase(client *kubernetes.Clientset, nodeName string, isControlPlaneNode bool, nodes []corev1.Node, running map[string]bool, cm *corev1.ConfigMap, phase string, caRotation *kubeadm.CARotation, rotationLock *lock.Semaphore) bool {
+	authority := cm.Data[caRotationAuthorityKey]
+	completed := cm.Data[caRotationIDKey] + "/" + phase
+
+	if caRotation.Participates(authority) {
+		if !isControlPlaneNode && phase == cm.Data[caRotationPhaseKey] && !caRotationCompleted(nodes, running, completed, true) {
+			logrus.Infof("Waiting for the control plane nodes to complete the CA %s rotation phase %s", authority, phase)
+			return false
+		}
+		// the tokens signed by the old service account key expire before the old key is dropped
+		if authority == kubeadm.ServiceAccountKey && phase == kubeadm.CAPhaseFinalize {
+			reissuedAt, err := time.Parse(time.RFC3339, cm.Data[caRotationReissuedAtKey])
+			if err != nil {
+				logrus.Errorf("Error in CA rotation ConfigMap %s/%s, waiting for a valid %s: %v", dsNamespace, caRotationName(), caRotationReissuedAtKey, err)
+				return false
+			}
+			if time.Since(reissuedAt) < serviceAccountTokenMaxLifetime {
+				logrus.Infof("Waiting until %v for the tokens signed by the old service account key to expire", reissuedAt.Add(serviceAccountTokenMaxLifetime))
+				return false
+			}
+		}
+
+		certPEM := []byte(cm.Data[caRotationCertificateKey])
+		if len(certPEM) == 0 {
+			logrus.Errorf("Error in CA rotation ConfigMap %s/%s: no %s", dsNamespace, caRotationName(), caRotationCertificateKey)
+			return false
+		}
+		// only the control plane nodes sign with the new private key
+		var keyPEM []byte
+		if isControlPlaneNode && phase == kubeadm.CAPhaseReissue {
+			secret, err := client.CoreV1().Secrets(dsNamespace).Get(context.TODO(), caRotationName(), metav1.GetOptions{})
+			if err != nil {
+				logrus.Errorf("Error getting CA rotation Secret %s/%s: %v", dsNamespace, caRotationName(), err)
+				return false
+			}
+			keyPEM = secret.Data[caRotationPrivateKeyKey]
+		}
+
+		rotationID := utilrand.String(5)
+		if !acquire(rotationLock, rotationID) {
+			return false
+		}
+		stopKeepAlive := keepAlive(rotationLock, rotationID)
+		err := caRotation.Run(phase, authority, certPEM, keyPEM)
+		stopKeepAlive()
+		release(rotationLock)
+		if err != nil {
+			logrus.Errorf("Error running the CA %s rotation phase %s, retrying at the next polling period: %v", authority, phase, err)
+			return false
+		}
+	}
+
+	if err := host.Annotate(client, nodeName, map[string]string{caRotationAnnotation: completed}); err != nil {
+		return false
+	}
+	logrus.Infof("The CA %s rotation phase %s is completed on the node", authority, phase)
+	return true
+}
+
+// missedCAPhases returns the CA rotation phases the node runs up to the current phase `phase`,
+// after the phase of the rotation `rotationID` recorded in the node annotation `completed`
+func missedCAPhases(completed, rotationID, phase string) []string {
+	next := kubeadm.CAPhaseTrust
+	if id, p, ok := strings.Cut(completed, "/"); ok && id == rotationID {
+		next = kubeadm.NextCAPhase(p)
+	}
+
+	phases := []string{}
+	for p := next; p != kubeadm.CAPhaseDone; p = kubeadm.NextCAPhase(p) {
+		phases = append(phases, p)
+		if p == phase {
+			return phases
+		}
+	}
+	// the node already completed the phase
+	return nil
+}
+
+// caRotationCompleted checks every node kucero runs on completed the CA rotation phase,
+// only the control plane nodes if `controlPlaneOnly` is true,
+// a NotReady node blocks the rotation until it completes the phase
+func caRotationCompleted(nodes []corev1.Node, running map[string]bool, completed string, controlPlaneOnly bool) bool {
+	for i := range nodes {
+		if !running[nodes[i].GetName()] {
+			continue
+		}
+		if controlPlaneOnly && !isControlPlane(&nodes[i]) {
+			continue
+		}
+		if nodes[i].GetAnnotations()[caRotationAnnotation] == completed {
+			continue
+		}
+		if !isNodeReady(&nodes[i]) {
+			logrus.Warnf("Waiting for the NotReady node %s to complete the CA rotation phase %s", nodes[i].GetName(), completed)
+		}
+		return false
+	}
+	return true
+}
+
+// kuceroNodes returns the nodes a running kucero DaemonSet pod is scheduled on,
+// the worker nodes run the --ds-name DaemonSet and the control plane nodes
+// the "<ds-name>-control-plane" DaemonSet with the control plane only identity
+func kuceroNodes(client kubernetes.Interface) (map[string]bool, error) {
+	nodes := map[string]bool{}
+	for _, name := range []string{dsName, dsName + "-control-plane"} {
+		ds, err := client.AppsV1().DaemonSets(dsNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector, err := metav1.LabelSelectorAsSelector(ds.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		pods, err := client.CoreV1().Pods(dsNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pod := range pods.Items {
+			if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil && pod.Spec.NodeName != "" {
+				nodes[pod.Spec.NodeName] = true
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// isNodeReady checks the node Ready condition is true
+func isNodeReady(corev1Node *corev1.Node) bool {
+	for _, condition := range corev1Node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
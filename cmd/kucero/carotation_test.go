@@ -0,0 +1,162 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jenting/kucero/pkg/pki/cert/kubeadm"
+)
+
+func Test_regenerateServiceAccountTokens(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "default"},
+			Type:       corev1.SecretTypeServiceAccountToken,
+			Data:       map[string][]byte{corev1.ServiceAccountTokenKey: []byte("old"), corev1.ServiceAccountRootCAKey: []byte("ca")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "regenerated", Namespace: "default", Annotations: map[string]string{caRotationAnnotation: "abcde"}},
+			Type:       corev1.SecretTypeServiceAccountToken,
+			Data:       map[string][]byte{corev1.ServiceAccountTokenKey: []byte("new")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: "default"},
+			Type:       corev1.SecretTypeOpaque,
+			Data:       map[string][]byte{corev1.ServiceAccountTokenKey: []byte("data")},
+		},
+	)
+
+	if err := regenerateServiceAccountTokens(client, "abcde"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		expectedToken string
+	}{
+		{name: "legacy", expectedToken: ""},
+		{name: "regenerated", expectedToken: "new"},
+		{name: "opaque", expectedToken: "data"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := client.CoreV1().Secrets("default").Get(context.TODO(), tt.name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token := string(secret.Data[corev1.ServiceAccountTokenKey]); token != tt.expectedToken {
+				t.Errorf("expected token %q, got %q", tt.expectedToken, token)
+			}
+			if secret.Type == corev1.SecretTypeServiceAccountToken && secret.GetAnnotations()[caRotationAnnotation] != "abcde" {
+				t.Errorf("expected the rotation ID annotation, got %v", secret.GetAnnotations())
+			}
+		})
+	}
+}
+
+func Test_missedCAPhases(t *testing.T) {
+	tests := []struct {
+		name      string
+		completed string
+		phase     string
+		expected  []string
+	}{
+		{
+			name:     "node new to the rotation",
+			phase:    kubeadm.CAPhaseTrust,
+			expected: []string{kubeadm.CAPhaseTrust},
+		},
+		{
+			name:      "node completed the previous phase",
+			completed: "abcde/" + kubeadm.CAPhaseTrust,
+			phase:     kubeadm.CAPhaseReissue,
+			expected:  []string{kubeadm.CAPhaseReissue},
+		},
+		{
+			name:      "node missed the trust and reissue phases",
+			completed: "vwxyz/" + kubeadm.CAPhaseFinalize,
+			phase:     kubeadm.CAPhaseFinalize,
+			expected:  []string{kubeadm.CAPhaseTrust, kubeadm.CAPhaseReissue, kubeadm.CAPhaseFinalize},
+		},
+		{
+			name:      "node missed the reissue phase",
+			completed: "abcde/" + kubeadm.CAPhaseTrust,
+			phase:     kubeadm.CAPhaseFinalize,
+			expected:  []string{kubeadm.CAPhaseReissue, kubeadm.CAPhaseFinalize},
+		},
+		{
+			name:      "node completed the phase",
+			completed: "abcde/" + kubeadm.CAPhaseReissue,
+			phase:     kubeadm.CAPhaseReissue,
+			expected:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			phases := missedCAPhases(tt.completed, "abcde", tt.phase)
+			if !reflect.DeepEqual(phases, tt.expected) {
+				t.Errorf("expected phases %v, got %v", tt.expected, phases)
+			}
+		})
+	}
+}
+
+func Test_kuceroNodes(t *testing.T) {
+	dsNamespace, dsName = "kube-system", "kucero"
+	daemonSet := func(name string) *appsv1.DaemonSet {
+		return &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: dsNamespace},
+			Spec:       appsv1.DaemonSetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"name": name}}},
+		}
+	}
+	pod := func(name, ds, nodeName string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: dsNamespace, Labels: map[string]string{"name": ds}},
+			Spec:       corev1.PodSpec{NodeName: nodeName},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		daemonSet("kucero"),
+		daemonSet("kucero-control-plane"),
+		pod("kucero-a", "kucero", "worker-1", corev1.PodRunning),
+		pod("kucero-b", "kucero", "worker-2", corev1.PodPending),
+		pod("kucero-control-plane-a", "kucero-control-plane", "master-1", corev1.PodRunning),
+		pod("other", "other", "worker-3", corev1.PodRunning),
+	)
+
+	nodes, err := kuceroNodes(client)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// both the worker and the control plane DaemonSet pods are waited for
+	expected := map[string]bool{"worker-1": true, "master-1": true}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("expected %v, got %v", expected, nodes)
+	}
+}
@@ -17,25 +17,24 @@ limitations under the License.
 package main
 
 import (
-	"time"
-
 	"github.com/sirupsen/logrus"
-	"github.com/weaveworks/kured/pkg/daemonsetlock"
+
+	"github.com/jenting/kucero/pkg/lock"
 )
 
-func holding(lock *daemonsetlock.DaemonSetLock, metadata interface{}) bool {
-	holding, err := lock.Test(metadata)
+func holding(l *lock.Semaphore) bool {
+	holding, holder, err := l.Holding()
 	if err != nil {
 		logrus.Errorf("Error testing lock: %v", err)
 	}
 	if holding {
-		logrus.Info("Holding lock")
+		logrus.Infof("Holding lock: %s", holder)
 	}
 	return holding
 }
 
-func acquire(lock *daemonsetlock.DaemonSetLock, metadata interface{}) bool {
-	holding, holder, err := lock.Acquire(metadata, time.Minute)
+func acquire(l *lock.Semaphore, rotationID string) bool {
+	holding, holder, err := l.Acquire(rotationID)
 	switch {
 	case err != nil:
 		logrus.Errorf("Error acquiring lock: %v", err)
@@ -44,14 +43,22 @@ func acquire(lock *daemonsetlock.DaemonSetLock, metadata interface{}) bool {
 		logrus.Warnf("Lock already held: %v", holder)
 		return false
 	default:
-		logrus.Info("Acquired kucero lock")
+		logrus.Infof("Acquired kucero lock: %s", holder)
 		return true
 	}
 }
 
-func release(lock *daemonsetlock.DaemonSetLock) {
+// keepAlive renews the lock while the rotation is running
+// returns the function to stop renewing
+func keepAlive(l *lock.Semaphore, rotationID string) func() {
+	return l.KeepAlive(rotationID, lockRenewInterval, func(err error) {
+		logrus.Errorf("Error renewing lock: %v", err)
+	})
+}
+
+func release(l *lock.Semaphore) {
 	logrus.Info("Releasing lock")
-	if err := lock.Release(); err != nil {
+	if err := l.Release(); err != nil {
 		logrus.Errorf("Error releasing lock: %v", err)
 	}
 }
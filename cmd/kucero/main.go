@@ -18,6 +18,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"math/rand"
 	"os"
 	"os/signal"
@@ -27,21 +28,29 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"github.com/weaveworks/kured/pkg/daemonsetlock"
 
+	"github.com/jenting/kucero/api/v1alpha1"
 	"github.com/jenting/kucero/controllers"
 	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/lock"
+	"github.com/jenting/kucero/pkg/metrics"
+	"github.com/jenting/kucero/pkg/pki/cert"
+	"github.com/jenting/kucero/pkg/pki/cert/kubeadm"
 	"github.com/jenting/kucero/pkg/pki/node"
 	"github.com/jenting/kucero/pkg/pki/signer"
+	"github.com/jenting/kucero/pkg/timewindow"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -58,12 +67,40 @@ var (
 	caCertPath, caKeyPath                       string
 	enableKubeletClientCertRotation             bool
 	enableKubeletServerCertRotation             bool
+	rotationDays                                []string
+	rotationStartTime, rotationEndTime          string
+	rotationTimeZone                            string
+	forceRotationBefore                         time.Duration
+	staticPodRestartTimeout                     time.Duration
+	verifyTimeout                               time.Duration
+	lockName                                    string
+	lockDuration, lockRenewInterval             time.Duration
+	maxConcurrentWorkerRotations                int
+	checkpointPath                              string
+	distribution                                string
+	kubeadmRenewal                              string
+	externalCASignerName, externalCACSRDir      string
+	kubeadmConfigPath                           string
+	keyAlgorithm                                string
+	keyAlgorithms                               map[string]string
+	enableCARotation                            bool
+	serviceAccountKeyMaxAge                     time.Duration
+	serviceAccountTokenMaxLifetime              time.Duration
+	drainTimeout                                time.Duration
+	drainGracePeriod                            int
+	drainPodSelector                            string
+	skipDrainControlPlane, drainDisableEviction bool
+	dryRun                                      bool
+	rotationHistoryLimit                        int
+	renewLifetimeFraction                       float64
+	renewLifetimeFractions                      map[string]string
 
 	scheme = runtime.NewScheme()
 )
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -74,29 +111,55 @@ func main() {
 		Run:   root,
 	}
 
+	rootCmd.AddCommand(newPlanCommand())
+	rootCmd.AddCommand(newRecoverCommand())
+
 	// general
 	rootCmd.PersistentFlags().StringVar(&apiServerHost, "master", "",
 		"Optional apiserver host address to connect to")
 	rootCmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "",
 		"Paths to a kubeconfig. Only required if out-of-cluster.")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"Prints the rotation plan every polling period without changing anything")
 
 	// kubeadm
 	rootCmd.PersistentFlags().DurationVar(&pollingPeriod, "polling-period", time.Hour,
 		"Certificate rotation check period")
 	rootCmd.PersistentFlags().DurationVar(&expiryTimeToRotate, "renew-before", time.Hour*24*30,
 		"Rotates certificate if certificate not after is below")
+	rootCmd.PersistentFlags().Float64Var(&renewLifetimeFraction, "renew-lifetime-fraction", 0,
+		"Rotates certificate once this fraction of its lifetime has elapsed, 0 disables it")
+	rootCmd.PersistentFlags().StringToStringVar(&renewLifetimeFractions, "renew-lifetime-fractions", map[string]string{},
+		"Overrides --renew-lifetime-fraction per certificate name, e.g. apiserver=0.5,admin.conf=0.8")
 	rootCmd.PersistentFlags().StringVar(&dsNamespace, "ds-namespace", "kube-system",
-		"The namespace containing daemonset on which to place lock")
+		"The namespace containing the kucero daemonset and the lock Lease")
 	rootCmd.PersistentFlags().StringVar(&dsName, "ds-name", "kucero",
-		"The name of daemonset on which to place lock")
+		"The name of the kucero daemonset, the CA rotation waits for the nodes its pods run on")
 	rootCmd.PersistentFlags().StringVar(&lockAnnotation, "lock-annotation", "caasp.suse.com/kucero-node-lock",
 		"The annotation in which to record locking node")
+	_ = rootCmd.PersistentFlags().MarkDeprecated("lock-annotation", "the lock holder is recorded in the Lease holder identity")
+	rootCmd.PersistentFlags().StringVar(&lockName, "lock-name", "kucero",
+		"The name prefix of the coordination.k8s.io Leases used as the rotation lock")
+	rootCmd.PersistentFlags().DurationVar(&lockDuration, "lock-duration", time.Minute*10,
+		"The lock is considered stale if the holder does not renew it within this duration")
+	rootCmd.PersistentFlags().DurationVar(&lockRenewInterval, "lock-renew-interval", time.Minute,
+		"The interval to renew the lock while rotating, must be less than --lock-duration")
+	rootCmd.PersistentFlags().IntVar(&maxConcurrentWorkerRotations, "max-concurrent-worker-rotations", 1,
+		"The number of worker nodes allowed to rotate at the same time, control plane nodes always rotate one at a time")
+
+	// rotation history
+	rootCmd.PersistentFlags().StringVar(&checkpointPath, "checkpoint-path", "/var/lib/kucero/checkpoint.json",
+		"The file persisting the rotation progress to resume an interrupted rotation")
+	rootCmd.PersistentFlags().IntVar(&rotationHistoryLimit, "rotation-history-limit", 10,
+		"The number of CertificateRotation objects to retain per node, 0 disables the rotation history")
+
+	// metrics
+	rootCmd.PersistentFlags().StringVar(&metricsAddr, "metrics-addr", ":8080",
+		"The address the metric endpoint binds to")
 
 	// kubelet CSR controller
 	rootCmd.PersistentFlags().BoolVar(&enableKubeletCSRController, "enable-kubelet-csr-controller", true,
 		"Enable kubelet CSR controller")
-	rootCmd.PersistentFlags().StringVar(&metricsAddr, "metrics-addr", ":8080",
-		"The address the metric endpoint binds to")
 	rootCmd.PersistentFlags().StringVar(&leaderElectionID, "leader-election-id", "kucero-leader-election",
 		"The name of the configmap used to coordinate leader election between kucero-controllers")
 	rootCmd.PersistentFlags().StringVar(&caCertPath, "ca-cert-path", "/etc/kubernetes/pki/ca.crt",
@@ -112,6 +175,59 @@ func main() {
 	rootCmd.PersistentFlags().BoolVar(&enableKubeletServerCertRotation, "enable-kubelet-server-cert-rotation", true,
 		"Enable kubelet server cert rotation")
 
+	// rotation window
+	rootCmd.PersistentFlags().StringSliceVar(&rotationDays, "rotation-days", timewindow.EveryDay,
+		"Only rotates certificate on these days, e.g. mon-fri")
+	rootCmd.PersistentFlags().StringVar(&rotationStartTime, "rotation-start-time", "0:00",
+		"Only rotates certificate after this time of day")
+	rootCmd.PersistentFlags().StringVar(&rotationEndTime, "rotation-end-time", "23:59:59",
+		"Only rotates certificate before this time of day")
+	rootCmd.PersistentFlags().StringVar(&rotationTimeZone, "rotation-time-zone", "UTC",
+		"The time zone of the rotation start and end time")
+	rootCmd.PersistentFlags().DurationVar(&forceRotationBefore, "force-rotation-before", time.Hour*24*3,
+		"Rotates certificate outside of the rotation window if certificate not after is below, 0 disables it")
+
+	// drain
+	rootCmd.PersistentFlags().DurationVar(&drainTimeout, "drain-timeout", time.Minute*10,
+		"The time to wait for the node drain before aborting the rotation, 0 waits forever")
+	rootCmd.PersistentFlags().IntVar(&drainGracePeriod, "drain-grace-period", -1,
+		"The pod termination grace period in seconds on drain, negative uses the pod default")
+	rootCmd.PersistentFlags().StringVar(&drainPodSelector, "drain-pod-selector", "",
+		"Only evicts the pods matching this label selector on drain")
+	rootCmd.PersistentFlags().BoolVar(&skipDrainControlPlane, "skip-drain-control-plane", false,
+		"Cordons but does not drain control plane nodes")
+	rootCmd.PersistentFlags().BoolVar(&drainDisableEviction, "drain-disable-eviction", false,
+		"Deletes the pods instead of evicting them on drain, which bypasses PodDisruptionBudgets")
+
+	rootCmd.PersistentFlags().StringVar(&distribution, "distribution", host.DistributionAuto,
+		"The Kubernetes distribution of the node, one of auto, kubeadm, k3s, rke2, auto detects it on the host")
+
+	// kubeadm
+	rootCmd.PersistentFlags().StringVar(&kubeadmRenewal, "kubeadm-renewal", kubeadm.RenewalNative,
+		"The kubeadm certificate renewal method, native renews with the CA on the host, kubeadm calls `kubeadm certs renew`, external requests an external CA to sign")
+	rootCmd.PersistentFlags().StringVar(&externalCASignerName, "external-ca-signer-name", "",
+		"The CertificateSigningRequest signerName of the external CA renewal, empty writes the CSRs for offline signing only")
+	rootCmd.PersistentFlags().StringVar(&externalCACSRDir, "external-ca-csr-dir", "/etc/kubernetes/kucero-csr",
+		"The folder of the external CA renewal CSRs, private keys and signed certificates")
+	rootCmd.PersistentFlags().StringVar(&kubeadmConfigPath, "kubeadm-config", "",
+		"The kubeadm ClusterConfiguration file locating the certificates, defaults to the kube-system/kubeadm-config ConfigMap")
+	rootCmd.PersistentFlags().StringVar(&keyAlgorithm, "key-algorithm", kubeadm.KeyReuse,
+		"The private key algorithm of the renewed kubeadm certificates, reuse keeps the existing key, or one of rsa-2048, rsa-3072, rsa-4096, ecdsa-p256, ecdsa-p384, ed25519 to regenerate it, requires the native or external renewal")
+	rootCmd.PersistentFlags().StringToStringVar(&keyAlgorithms, "key-algorithms", map[string]string{},
+		"Overrides --key-algorithm per kubeadm certificate name, e.g. apiserver=ecdsa-p256,admin.conf=reuse")
+	rootCmd.PersistentFlags().BoolVar(&enableCARotation, "enable-ca-rotation", false,
+		"Enable the staged kubeadm CA rotation, started when a CA is going to expire")
+	rootCmd.PersistentFlags().DurationVar(&serviceAccountKeyMaxAge, "service-account-key-max-age", 0,
+		"Rotates the kubeadm service account signing key with the CA rotation once it is older, 0 disables it")
+	rootCmd.PersistentFlags().DurationVar(&serviceAccountTokenMaxLifetime, "service-account-token-max-lifetime", time.Hour*48,
+		"The time to wait after signing with the new service account key before dropping the old key, at least the apiserver --service-account-max-token-expiration")
+
+	// static pods
+	rootCmd.PersistentFlags().DurationVar(&staticPodRestartTimeout, "static-pod-restart-timeout", time.Minute*5,
+		"The time to wait for a restarted control plane static pod to be Ready")
+	rootCmd.PersistentFlags().DurationVar(&verifyTimeout, "verify-timeout", time.Minute*5,
+		"The time to wait for the node to be healthy after rotation before rolling back the certificates")
+
 	if err := rootCmd.Execute(); err != nil {
 		logrus.Error(err)
 	}
@@ -120,17 +236,78 @@ func main() {
 func root(cmd *cobra.Command, args []string) {
 	logrus.Infof("KUbernetes CErtificate ROtation Daemon: %s", version)
 
-	nodeName := os.Getenv("KUCERO_NODE_NAME")
-	if nodeName == "" {
-		logrus.Fatal("KUCERO_NODE_NAME environment variable required")
-	}
+	config, client, corev1Node, expiryPolicy, rotationWindow := setup()
+	nodeName := corev1Node.GetName()
+	isControlPlaneNode := isControlPlane(corev1Node)
 
 	// shifting certificate check polling period
 	rand.Seed(time.Now().UnixNano())
 	extra := rand.Intn(int(pollingPeriod.Seconds()))
 	pollingPeriod = pollingPeriod + time.Duration(extra)*time.Second
 
-	// check it's a control plane node or worker node
+	logrus.Infof("Node Name: %s", nodeName)
+	logrus.Infof("Lock Leases: %s/%s-{control-plane,worker}-*, duration %v, renew interval %v", dsNamespace, lockName, lockDuration, lockRenewInterval)
+	logrus.Infof("Max Concurrent Rotations: control plane 1, worker %d", maxConcurrentWorkerRotations)
+	logrus.Infof("Shifted Certificate Check Polling Period %v", pollingPeriod)
+	logrus.Infof("Rotates Certificate If Expiry Time Less Than %v", expiryTimeToRotate)
+	if expiryPolicy.LifetimeFraction > 0 || len(expiryPolicy.LifetimeFractions) > 0 {
+		logrus.Infof("Rotates Certificate If Elapsed Lifetime More Than %v, Overrides %v", expiryPolicy.LifetimeFraction, expiryPolicy.LifetimeFractions)
+	}
+	logrus.Infof("Rotation Window: %v", rotationWindow)
+	logrus.Infof("Forces Rotation Outside Window If Expiry Time Less Than %v", forceRotationBefore)
+	logrus.Infof("Drain Timeout: %v, Grace Period: %d, Pod Selector: %q, Disable Eviction: %t", drainTimeout, drainGracePeriod, drainPodSelector, drainDisableEviction)
+	logrus.Infof("Skip Drain Control Plane: %t", skipDrainControlPlane)
+	logrus.Infof("Distribution: %s", nodeDistribution())
+	logrus.Infof("Kubeadm Certificate Renewal: %s", kubeadmRenewal)
+	if kubeadmRenewal == kubeadm.RenewalExternal {
+		logrus.Infof("External CA Signer Name: %q, CSR Folder: %s", externalCASignerName, externalCACSRDir)
+	}
+	if kubeadmConfigPath != "" {
+		logrus.Infof("Kubeadm ClusterConfiguration: %s", kubeadmConfigPath)
+	}
+	logrus.Infof("Kubeadm Certificate Key Algorithm: %s, Overrides %v", keyAlgorithm, keyAlgorithms)
+	logrus.Infof("Kubeadm CA rotation enabled: %t", enableCARotation)
+	if enableCARotation && serviceAccountKeyMaxAge > 0 {
+		logrus.Infof("Service Account Key Max Age: %v, Token Max Lifetime: %v", serviceAccountKeyMaxAge, serviceAccountTokenMaxLifetime)
+	}
+	logrus.Infof("Static Pod Restart Timeout: %v", staticPodRestartTimeout)
+	logrus.Infof("Rotation Verify Timeout: %v", verifyTimeout)
+	logrus.Infof("Kubelet client cert rotation enabled: %t", enableKubeletClientCertRotation)
+	logrus.Infof("Kubelet server cert rotation enabled: %t", enableKubeletServerCertRotation)
+	if enableKubeletCSRController && isControlPlaneNode {
+		logrus.Infof("Kubelet CSR controller leader election ID: %s", leaderElectionID)
+		logrus.Infof("Kubelet CSR controller CA cert: %s", caCertPath)
+		logrus.Infof("Kubelet CSR controller CA key: %s", caKeyPath)
+	}
+	if dryRun {
+		logrus.Info("Dry run enabled, nothing will be changed")
+	}
+
+	rotateCertificateWhenNeeded(config, corev1Node, isControlPlaneNode, client, expiryPolicy, rotationWindow)
+}
+
+// setup validates the command line flags, connects to the apiserver
+// and returns the node this kucero runs on
+func setup() (*rest.Config, *kubernetes.Clientset, *corev1.Node, cert.ExpiryPolicy, *timewindow.TimeWindow) {
+	nodeName := os.Getenv("KUCERO_NODE_NAME")
+	if nodeName == "" {
+		logrus.Fatal("KUCERO_NODE_NAME environment variable required")
+	}
+
+	expiryPolicy := newExpiryPolicy()
+
+	// fails fast on an invalid distribution, renewal method or key algorithm
+	nodeDistribution()
+	newKubeadmRenewal()
+	if lockRenewInterval <= 0 || lockRenewInterval >= lockDuration {
+		logrus.Fatalf("--lock-renew-interval %v must be positive and less than --lock-duration %v", lockRenewInterval, lockDuration)
+	}
+	if maxConcurrentWorkerRotations < 1 {
+		logrus.Fatalf("--max-concurrent-worker-rotations %d must be at least 1", maxConcurrentWorkerRotations)
+	}
+
+	rotationWindow := newRotationWindow()
+
 	config, err := clientcmd.BuildConfigFromFlags(apiServerHost, kubeconfig)
 	if err != nil {
 		logrus.Fatal(err)
@@ -146,47 +323,118 @@ func root(cmd *cobra.Command, args []string) {
 		logrus.Fatal(err)
 	}
 
-	_, master := corev1Node.GetLabels()["node-role.kubernetes.io/master"]
-	_, controlPlane := corev1Node.GetLabels()["node-role.kubernetes.io/control-plane"]
-	isControlPlaneNode := master || controlPlane
+	return config, client, corev1Node, expiryPolicy, rotationWindow
+}
 
-	logrus.Infof("Node Name: %s", nodeName)
-	logrus.Infof("Lock Annotation: %s/%s:%s", dsNamespace, dsName, lockAnnotation)
-	logrus.Infof("Shifted Certificate Check Polling Period %v", pollingPeriod)
-	logrus.Infof("Rotates Certificate If Expiry Time Less Than %v", expiryTimeToRotate)
-	logrus.Infof("Kubelet client cert rotation enabled: %t", enableKubeletClientCertRotation)
-	logrus.Infof("Kubelet server cert rotation enabled: %t", enableKubeletServerCertRotation)
-	if enableKubeletCSRController && isControlPlaneNode {
-		logrus.Infof("Kubelet CSR controller leader election ID: %s", leaderElectionID)
-		logrus.Infof("Kubelet CSR controller CA cert: %s", caCertPath)
-		logrus.Infof("Kubelet CSR controller CA key: %s", caKeyPath)
+// newExpiryPolicy returns the certificate expiry policy from the command line flags
+func newExpiryPolicy() cert.ExpiryPolicy {
+	if err := cert.ValidateLifetimeFraction(renewLifetimeFraction); err != nil {
+		logrus.Fatal(err)
+	}
+	lifetimeFractions, err := cert.ParseLifetimeFractions(renewLifetimeFractions)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	return cert.ExpiryPolicy{
+		RenewBefore:       expiryTimeToRotate,
+		LifetimeFraction:  renewLifetimeFraction,
+		LifetimeFractions: lifetimeFractions,
+	}
+}
+
+// nodeDistribution returns the Kubernetes distribution of the node
+// from the command line flags, detected on the host if auto
+func nodeDistribution() string {
+	if err := host.ValidateDistribution(distribution); err != nil {
+		logrus.Fatal(err)
+	}
+	if distribution == host.DistributionAuto {
+		return host.DetectDistribution()
+	}
+	return distribution
+}
+
+// newKubeadmRenewal returns the kubeadm certificate renewal from the command line flags
+func newKubeadmRenewal() kubeadm.Renewal {
+	renewal, err := kubeadm.NewRenewal(kubeadmRenewal, keyAlgorithm, keyAlgorithms)
+	if err != nil {
+		logrus.Fatal(err)
 	}
+	renewal.SignerName = externalCASignerName
+	renewal.CSRDir = externalCACSRDir
+	return renewal
+}
 
-	rotateCertificateWhenNeeded(corev1Node, isControlPlaneNode, client)
+// newRotationWindow returns the rotation window from the command line flags
+func newRotationWindow() *timewindow.TimeWindow {
+	rotationWindow, err := timewindow.New(rotationDays, rotationStartTime, rotationEndTime, rotationTimeZone)
+	if err != nil {
+		logrus.Fatalf("Failed to build rotation window: %v", err)
+	}
+	return rotationWindow
 }
 
-// nodeMeta is used to remember information across nodes
-// whom is doing certificate rotation
-type nodeMeta struct {
-	Unschedulable bool `json:"unschedulable"`
+// caKeyAvailable checks the CA private key exists,
+// kubeadm external CA mode removes it from the nodes
+func caKeyAvailable(caKeyPath string) bool {
+	_, err := os.Stat(caKeyPath)
+	return !errors.Is(err, os.ErrNotExist)
 }
 
-func rotateCertificateWhenNeeded(corev1Node *corev1.Node, isControlPlaneNode bool, client *kubernetes.Clientset) {
+// isControlPlane checks it's a control plane node or worker node
+func isControlPlane(corev1Node *corev1.Node) bool {
+	_, master := corev1Node.GetLabels()["node-role.kubernetes.io/master"]
+	_, controlPlane := corev1Node.GetLabels()["node-role.kubernetes.io/control-plane"]
+	return master || controlPlane
+}
+
+func rotateCertificateWhenNeeded(config *rest.Config, corev1Node *corev1.Node, isControlPlaneNode bool, client *kubernetes.Clientset, expiryPolicy cert.ExpiryPolicy, rotationWindow *timewindow.TimeWindow) {
 	nodeName := corev1Node.GetName()
-	certNode := node.New(isControlPlaneNode, nodeName, expiryTimeToRotate, enableKubeletClientCertRotation, enableKubeletServerCertRotation)
+	certNode := node.New(nodeDistribution(), isControlPlaneNode, nodeName, client, expiryPolicy, staticPodRestartTimeout, verifyTimeout, newKubeadmRenewal(), kubeadmConfigPath, enableKubeletClientCertRotation, enableKubeletServerCertRotation)
+
+	go metrics.Serve(metricsAddr)
+
+	// records the rotation history as CertificateRotation objects
+	var recordClient ctrlclient.Client
+	if rotationHistoryLimit > 0 {
+		c, err := ctrlclient.New(config, ctrlclient.Options{Scheme: scheme})
+		if err != nil {
+			logrus.Errorf("Error creating CertificateRotation client, rotation history disabled: %v", err)
+		} else {
+			recordClient = c
+		}
+	}
 
-	lock := daemonsetlock.New(client, nodeName, dsNamespace, dsName, lockAnnotation)
-	nodeMeta := nodeMeta{}
-	if holding(lock, &nodeMeta) {
-		release(lock)
+	// control plane nodes rotate one at a time to keep the control plane quorum
+	rotationLock := lock.NewSemaphore(client, dsNamespace, lockName+"-worker", nodeName, maxConcurrentWorkerRotations, lockDuration)
+	if isControlPlaneNode {
+		rotationLock = lock.NewSemaphore(client, dsNamespace, lockName+"-control-plane", nodeName, 1, lockDuration)
+	}
+	logrus.Infof("Rotation lock pool %s with %d slots", rotationLock.Pool(), rotationLock.Slots())
+
+	// the CA rotation phases are gated by the rotation lock too
+	var caRotation *kubeadm.CARotation
+	if enableCARotation {
+		if kubeadmRenewal == kubeadm.RenewalExternal {
+			logrus.Warn("The CA rotation requires the CA keys, it is not supported with the external CA renewal, disabling it")
+		} else if certNode.Distribution == host.DistributionKubeadm {
+			caRotation = kubeadm.NewCARotation(nodeName, client, isControlPlaneNode, staticPodRestartTimeout, verifyTimeout, kubeadmConfigPath)
+		} else {
+			logrus.Warnf("The CA rotation is not supported on %s, disabling it", certNode.Distribution)
+		}
+	}
+	if !dryRun {
+		resumeRotation(client, recordClient, corev1Node, certNode, rotationLock)
 	}
 
-	if enableKubeletCSRController && isControlPlaneNode {
+	// the leader control plane node issues the bootstrap tokens and signs the kubelet serving CSRs
+	if isControlPlaneNode && !dryRun {
 		go func() {
 			mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 				Scheme: scheme,
+				// the metrics are served by kucero on every node
 				Metrics: metricsserver.Options{
-					BindAddress: metricsAddr,
+					BindAddress: "0",
 				},
 				LeaderElection:          true,
 				LeaderElectionNamespace: dsNamespace,
@@ -196,19 +444,32 @@ func rotateCertificateWhenNeeded(corev1Node *corev1.Node, isControlPlaneNode boo
 				logrus.Fatal(err)
 			}
 
-			signer, err := signer.NewSigner(caCertPath, caKeyPath, duration)
-			if err != nil {
+			clientSet := kubernetes.NewForConfigOrDie(mgr.GetConfig())
+			if err := (&controllers.BootstrapTokenReconciler{
+				ClientSet:     clientSet,
+				EventRecorder: mgr.GetEventRecorderFor("BootstrapTokenReconciler"),
+			}).SetupWithManager(mgr); err != nil {
 				logrus.Fatal(err)
 			}
 
-			if err := (&controllers.CertificateSigningRequestSigningReconciler{
-				Client:        mgr.GetClient(),
-				ClientSet:     kubernetes.NewForConfigOrDie(mgr.GetConfig()),
-				Scheme:        mgr.GetScheme(),
-				Signer:        signer,
-				EventRecorder: mgr.GetEventRecorderFor("CSRSigningReconciler"),
-			}).SetupWithManager(mgr); err != nil {
-				logrus.Fatal(err)
+			// kubeadm external CA mode has no CA key on the nodes to sign with
+			if enableKubeletCSRController && !caKeyAvailable(caKeyPath) {
+				logrus.Warnf("The CA key %s is not found, the kubelet CSR controller is disabled", caKeyPath)
+			} else if enableKubeletCSRController {
+				signer, err := signer.NewSigner(caCertPath, caKeyPath, duration)
+				if err != nil {
+					logrus.Fatal(err)
+				}
+
+				if err := (&controllers.CertificateSigningRequestSigningReconciler{
+					Client:        mgr.GetClient(),
+					ClientSet:     clientSet,
+					Scheme:        mgr.GetScheme(),
+					Signer:        signer,
+					EventRecorder: mgr.GetEventRecorderFor("CSRSigningReconciler"),
+				}).SetupWithManager(mgr); err != nil {
+					logrus.Fatal(err)
+				}
 			}
 			//+kubebuilder:scaffold:builder
 
@@ -231,53 +492,43 @@ func rotateCertificateWhenNeeded(corev1Node *corev1.Node, isControlPlaneNode boo
 		case <-ch:
 			logrus.Info("Check certificate expiration")
 
-			// check the configuration needs to be update
-			configsToBeUpdate, err := certNode.CheckConfig()
-			if err != nil {
-				logrus.Error(err)
+			plan := newRotationPlan(corev1Node, isControlPlaneNode, certNode, rotationWindow, dryRun)
+			if dryRun {
+				observeCertificateStatus(plan)
+				printRotationPlan(plan)
+				continue
 			}
-
-			// check the certificate needs expiration
-			expiryCerts, err := certNode.CheckExpiration()
-			if err != nil {
-				logrus.Error(err)
+			publishCertificateStatus(client, plan)
+			if len(plan.Pending) > 0 {
+				if err := certNode.RequestCertificates(plan.Pending); err != nil {
+					logrus.Errorf("Error requesting the certificates to the external CA: %v", err)
+				}
+			}
+			if caRotation != nil {
+				rotateCertificateAuthority(client, nodeName, isControlPlaneNode, caRotation, rotationLock, plan, expiryPolicy)
+			}
+			if !plan.Rotate {
+				if plan.Deferred {
+					logrus.Infof("Outside of rotation window %v, deferring rotation", rotationWindow)
+				}
+				continue
 			}
 
 			// rotates the certificate if there are certificates going to expire
 			// and the lock can be acquired.
 			// if the lock cannot be acquired, it will wait `pollingPeriod` time
 			// and try to acquire the lock again.
-			if (len(configsToBeUpdate) > 0 || len(expiryCerts) > 0) && acquire(lock, &nodeMeta) {
-				if !nodeMeta.Unschedulable {
-					_ = host.Cordon(client, corev1Node)
-					_ = host.Drain(client, corev1Node)
-				}
-
-				if len(configsToBeUpdate) > 0 {
-					logrus.Infof("The configuration need to be updates are %v", configsToBeUpdate)
-
-					logrus.Info("Waiting for configuration to be update")
-					if err := certNode.UpdateConfig(configsToBeUpdate); err != nil {
-						logrus.Error(err)
-					}
-					logrus.Info("Update configuration done")
-				}
-
-				if len(expiryCerts) > 0 {
-					logrus.Infof("The expiry certificiates are %v", expiryCerts)
-
-					logrus.Info("Waiting for certificate rotation")
-					if err := certNode.Rotate(expiryCerts); err != nil {
-						logrus.Error(err)
-					}
-					logrus.Info("Certificate rotation done")
-				}
-
-				if !nodeMeta.Unschedulable {
-					_ = host.Uncordon(client, corev1Node)
-				}
-
-				release(lock)
+			rotationID := utilrand.String(5)
+			if acquire(rotationLock, rotationID) {
+				stopKeepAlive := keepAlive(rotationLock, rotationID)
+				record := newRotationRecord(recordClient, plan, rotationID)
+				err := rotate(client, nodeName, certNode, plan, record)
+				publishRotationResult(client, nodeName, err)
+				pruneRotationRecords(recordClient, nodeName, rotationHistoryLimit)
+				publishCertificateStatus(client, newRotationPlan(corev1Node, isControlPlaneNode, certNode, rotationWindow, false))
+
+				stopKeepAlive()
+				release(rotationLock)
 			}
 		}
 	}
@@ -0,0 +1,253 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/pki/cert"
+	"github.com/jenting/kucero/pkg/pki/cert/kubeadm"
+	"github.com/jenting/kucero/pkg/pki/node"
+	"github.com/jenting/kucero/pkg/timewindow"
+)
+
+// rotationPlan describes what kucero does on the node
+// at this polling period
+type rotationPlan struct {
+	Node         string `json:"node"`
+	Distribution string `json:"distribution"`
+	ControlPlane bool   `json:"controlPlane"`
+
+	// Configs are the kubelet configuration files to be updated
+	Configs []configPlan `json:"configs,omitempty"`
+	// Certificates are the certificates to be renewed
+	Certificates []string `json:"certificates,omitempty"`
+	// Drifted are the certificates to be reissued since their identity drifted
+	Drifted []cert.Drift `json:"drifted,omitempty"`
+	// Pending are the certificates waiting to be signed by the external CA
+	Pending []string `json:"pending,omitempty"`
+	// Rejected are the pending certificates whose request the external CA rejected
+	Rejected []cert.Rejection `json:"rejected,omitempty"`
+	// EarliestExpiry is the earliest notAfter of the node certificates
+	EarliestExpiry *time.Time `json:"earliestExpiry,omitempty"`
+
+	InRotationWindow bool `json:"inRotationWindow"`
+	ForceRotation    bool `json:"forceRotation"`
+	// Deferred is true if there is work to do but outside of the rotation window
+	Deferred bool `json:"deferred"`
+	// Rotate is true if kucero acquires the lock and rotates
+	Rotate bool `json:"rotate"`
+
+	Cordon         bool `json:"cordon"`
+	Drain          bool `json:"drain"`
+	Uncordon       bool `json:"uncordon"`
+	RestartKubelet bool `json:"restartKubelet"`
+	// RestartStaticPods are the control plane static pods to be restarted
+	RestartStaticPods []string `json:"restartStaticPods,omitempty"`
+
+	Errors []string `json:"errors,omitempty"`
+
+	// infos are the node certificates information
+	infos []cert.Info
+	// expiring are the certificates which are going to expire
+	expiring []string
+}
+
+type configPlan struct {
+	Path string `json:"path"`
+	Diff string `json:"diff,omitempty"`
+}
+
+func newPlanCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "plan",
+		Short: "Prints the certificate rotation plan of the node without changing anything",
+		Run:   plan,
+	}
+}
+
+func plan(cmd *cobra.Command, args []string) {
+	_, client, corev1Node, expiryPolicy, rotationWindow := setup()
+	isControlPlaneNode := isControlPlane(corev1Node)
+	certNode := node.New(nodeDistribution(), isControlPlaneNode, corev1Node.GetName(), client, expiryPolicy, staticPodRestartTimeout, verifyTimeout, newKubeadmRenewal(), kubeadmConfigPath, enableKubeletClientCertRotation, enableKubeletServerCertRotation)
+
+	printRotationPlan(newRotationPlan(corev1Node, isControlPlaneNode, certNode, rotationWindow, true))
+}
+
+// newRotationPlan checks the configuration and the certificate expiration
+// returns the rotation plan, the configuration diff is computed if `withDiff` is true
+func newRotationPlan(corev1Node *corev1.Node, isControlPlaneNode bool, certNode *node.Node, rotationWindow *timewindow.TimeWindow, withDiff bool) *rotationPlan {
+	plan := &rotationPlan{
+		Node:         corev1Node.GetName(),
+		Distribution: certNode.Distribution,
+		ControlPlane: isControlPlaneNode,
+	}
+
+	// check the configuration needs to be update
+	configsToBeUpdate, err := certNode.CheckConfig()
+	if err != nil {
+		logrus.Error(err)
+		plan.Errors = append(plan.Errors, err.Error())
+	}
+
+	var diffs map[string]string
+	if withDiff && len(configsToBeUpdate) > 0 {
+		diffs, err = certNode.DiffConfig(configsToBeUpdate)
+		if err != nil {
+			logrus.Error(err)
+			plan.Errors = append(plan.Errors, err.Error())
+		}
+	}
+	for _, config := range configsToBeUpdate {
+		plan.Configs = append(plan.Configs, configPlan{Path: config, Diff: diffs[config]})
+	}
+
+	// check the certificate needs expiration
+	plan.expiring, err = certNode.CheckExpiration()
+	if err != nil {
+		logrus.Error(err)
+		plan.Errors = append(plan.Errors, err.Error())
+	}
+	plan.Certificates = append(plan.Certificates, plan.expiring...)
+
+	// the drifted certificates are renewed whatever their expiry
+	plan.Drifted, err = certNode.CheckDrift()
+	if err != nil {
+		logrus.Error(err)
+		plan.Errors = append(plan.Errors, err.Error())
+	}
+	for _, drift := range plan.Drifted {
+		if !contains(plan.Certificates, drift.Name) {
+			plan.Certificates = append(plan.Certificates, drift.Name)
+		}
+	}
+
+	// the certificates renewed by an external CA are rotated once signed
+	plan.Certificates, plan.Pending, plan.Rejected = certNode.SignedCertificates(plan.Certificates)
+
+	plan.infos, err = certNode.Inspect()
+	if err != nil {
+		logrus.Error(err)
+		plan.Errors = append(plan.Errors, err.Error())
+	}
+	for i := range plan.infos {
+		if plan.EarliestExpiry == nil || plan.infos[i].NotAfter.Before(*plan.EarliestExpiry) {
+			plan.EarliestExpiry = &plan.infos[i].NotAfter
+		}
+	}
+
+	if len(plan.Configs) == 0 && len(plan.Certificates) == 0 {
+		return plan
+	}
+
+	// defers the rotation to the next rotation window
+	// unless a certificate is going to expire before then
+	plan.InRotationWindow = rotationWindow.Contains(time.Now())
+	if !plan.InRotationWindow {
+		plan.ForceRotation = forceRotation(plan.infos)
+	}
+	plan.Rotate = plan.InRotationWindow || plan.ForceRotation
+	plan.Deferred = !plan.Rotate
+	if !plan.Rotate {
+		return plan
+	}
+
+	plan.Cordon = true
+	plan.Drain = !(isControlPlaneNode && skipDrainControlPlane)
+	plan.Uncordon = true
+	plan.RestartKubelet = true
+	if isControlPlaneNode && certNode.Distribution == host.DistributionKubeadm {
+		plan.RestartStaticPods = kubeadm.StaticPods(plan.Certificates)
+	}
+
+	return plan
+}
+
+// configPaths returns the configuration files to be updated
+func (p *rotationPlan) configPaths() []string {
+	paths := []string{}
+	for _, config := range p.Configs {
+		paths = append(paths, config.Path)
+	}
+	return paths
+}
+
+// driftedCertificates returns the certificates whose identity drifted
+func (p *rotationPlan) driftedCertificates() []string {
+	names := []string{}
+	for _, drift := range p.Drifted {
+		names = append(names, drift.Name)
+	}
+	return names
+}
+
+// rejectedCertificates returns the certificates whose request the external CA rejected
+func (p *rotationPlan) rejectedCertificates() []string {
+	names := []string{}
+	for _, rejection := range p.Rejected {
+		names = append(names, rejection.Name)
+	}
+	return names
+}
+
+// printRotationPlan prints the rotation plan to stdout in YAML
+func printRotationPlan(plan *rotationPlan) {
+	out, err := yaml.Marshal(plan)
+	if err != nil {
+		logrus.Errorf("Error printing rotation plan: %v", err)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "---\n%s", out)
+}
+
+// forceRotation checks if any certificate not after is below
+// the forced rotation threshold
+func forceRotation(infos []cert.Info) bool {
+	if forceRotationBefore <= 0 {
+		return false
+	}
+
+	for _, info := range infos {
+		// the CAs are rotated by the CA rotation
+		if info.IsCA {
+			continue
+		}
+		if time.Until(info.NotAfter) <= forceRotationBefore {
+			logrus.Warnf("The certificate %s notAfter is less than %s, forcing rotation outside of rotation window", info.Name, forceRotationBefore)
+			return true
+		}
+	}
+	return false
+}
+
+func contains(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
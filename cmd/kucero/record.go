@@ -0,0 +1,224 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jenting/kucero/api/v1alpha1"
+	"github.com/jenting/kucero/pkg/checkpoint"
+)
+
+// Rotation phases recorded in the CertificateRotation status
+const (
+	phaseCordon       = "cordon"
+	phaseDrain        = "drain"
+	phaseUpdateConfig = "update-config"
+	phaseRenew        = "renew"
+	phaseVerify       = "verify"
+	phaseRollback     = "rollback"
+	phaseUncordon     = "uncordon"
+)
+
+// rotationRecord records a certificate rotation run
+// as a CertificateRotation object and a checkpoint file on the host
+type rotationRecord struct {
+	client     ctrlclient.Client
+	rotation   *v1alpha1.CertificateRotation
+	checkpoint *checkpoint.Checkpoint
+}
+
+// newRotationRecord creates the checkpoint and the CertificateRotation object `<node>-<rotation id>`
+// of the rotation plan, the object is not recorded if the client is nil or the object cannot be created
+func newRotationRecord(client ctrlclient.Client, plan *rotationPlan, rotationID string) *rotationRecord {
+	r := &rotationRecord{
+		client: client,
+		checkpoint: &checkpoint.Checkpoint{
+			RotationID:   rotationID,
+			Node:         plan.Node,
+			Certificates: plan.Certificates,
+			Configs:      plan.configPaths(),
+		},
+	}
+	r.saveCheckpoint()
+	if client == nil {
+		return r
+	}
+
+	now := metav1.Now()
+	rotation := &v1alpha1.CertificateRotation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: plan.Node + "-" + rotationID,
+			Labels: map[string]string{
+				v1alpha1.NodeLabel: plan.Node,
+			},
+		},
+		Spec: v1alpha1.CertificateRotationSpec{
+			NodeName:     plan.Node,
+			ControlPlane: plan.ControlPlane,
+			Certificates: plan.Certificates,
+			Configs:      plan.configPaths(),
+		},
+	}
+	if err := client.Create(context.TODO(), rotation); err != nil {
+		logrus.Errorf("Error creating CertificateRotation: %v", err)
+		return r
+	}
+
+	rotation.Status = v1alpha1.CertificateRotationStatus{
+		Outcome:   v1alpha1.RotationRunning,
+		StartTime: &now,
+	}
+	r.rotation = rotation
+	r.update()
+
+	logrus.Infof("Recording certificate rotation %s", rotation.Name)
+	return r
+}
+
+// resumeRotationRecord loads the checkpoint and the CertificateRotation object
+// of the rotation interrupted by a kucero restart
+func resumeRotationRecord(client ctrlclient.Client, c *checkpoint.Checkpoint) *rotationRecord {
+	r := &rotationRecord{client: client, checkpoint: c}
+	if client == nil {
+		return r
+	}
+
+	rotation := &v1alpha1.CertificateRotation{}
+	key := ctrlclient.ObjectKey{Name: c.Node + "-" + c.RotationID}
+	if err := client.Get(context.TODO(), key, rotation); err != nil {
+		logrus.Errorf("Error getting CertificateRotation %s: %v", key.Name, err)
+		return r
+	}
+	r.rotation = rotation
+	return r
+}
+
+// startPhase records the phase start time
+func (r *rotationRecord) startPhase(name string) {
+	if r.checkpoint != nil {
+		r.checkpoint.StartPhase(name, time.Now())
+		r.saveCheckpoint()
+	}
+	if r.rotation == nil {
+		return
+	}
+
+	now := metav1.Now()
+	r.rotation.Status.Phases = append(r.rotation.Status.Phases, v1alpha1.PhaseStatus{
+		Name:      name,
+		StartTime: &now,
+	})
+	r.update()
+}
+
+// endPhase records the phase completion time and error
+func (r *rotationRecord) endPhase(name string, err error) {
+	if r.checkpoint != nil {
+		r.checkpoint.EndPhase(name)
+		r.saveCheckpoint()
+	}
+	if r.rotation == nil {
+		return
+	}
+
+	now := metav1.Now()
+	for i := range r.rotation.Status.Phases {
+		phase := &r.rotation.Status.Phases[i]
+		if phase.Name != name || phase.CompletionTime != nil {
+			continue
+		}
+		phase.CompletionTime = &now
+		if err != nil {
+			phase.Error = err.Error()
+		}
+	}
+	r.update()
+}
+
+// complete records the backups and the final outcome of the rotation
+// and removes the checkpoint, the recorded backups are kept if `backups` is nil
+func (r *rotationRecord) complete(backups []string, err error) {
+	if r.checkpoint != nil {
+		if err := checkpoint.Remove(checkpointPath); err != nil {
+			logrus.Errorf("Error removing checkpoint %s: %v", checkpointPath, err)
+		}
+	}
+	if r.rotation == nil {
+		return
+	}
+
+	now := metav1.Now()
+	if backups != nil {
+		r.rotation.Status.Backups = backups
+	}
+	r.rotation.Status.CompletionTime = &now
+	r.rotation.Status.Outcome = v1alpha1.RotationSucceeded
+	if err != nil {
+		r.rotation.Status.Outcome = v1alpha1.RotationFailed
+		r.rotation.Status.Error = err.Error()
+	}
+	r.update()
+}
+
+func (r *rotationRecord) update() {
+	if err := r.client.Status().Update(context.TODO(), r.rotation); err != nil {
+		logrus.Errorf("Error updating CertificateRotation %s: %v", r.rotation.Name, err)
+	}
+}
+
+// saveCheckpoint persists the rotation progress on the host
+func (r *rotationRecord) saveCheckpoint() {
+	if err := checkpoint.Save(checkpointPath, r.checkpoint); err != nil {
+		logrus.Errorf("Error saving checkpoint %s: %v", checkpointPath, err)
+	}
+}
+
+// pruneRotationRecords deletes the oldest CertificateRotation objects of the node
+// and keeps the latest `limit` objects
+func pruneRotationRecords(client ctrlclient.Client, nodeName string, limit int) {
+	if client == nil {
+		return
+	}
+
+	list := &v1alpha1.CertificateRotationList{}
+	if err := client.List(context.TODO(), list, ctrlclient.MatchingLabels{v1alpha1.NodeLabel: nodeName}); err != nil {
+		logrus.Errorf("Error listing CertificateRotation: %v", err)
+		return
+	}
+	if len(list.Items) <= limit {
+		return
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].CreationTimestamp.Before(&list.Items[j].CreationTimestamp)
+	})
+	for i := range list.Items[:len(list.Items)-limit] {
+		rotation := &list.Items[i]
+		logrus.Infof("Pruning CertificateRotation %s", rotation.Name)
+		if err := client.Delete(context.TODO(), rotation); ctrlclient.IgnoreNotFound(err) != nil {
+			logrus.Errorf("Error deleting CertificateRotation %s: %v", rotation.Name, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/lock"
+	"github.com/jenting/kucero/pkg/pki/node"
+)
+
+// adminKubeconfig is the kubeadm admin kubeconfig renewed by the recovery
+const adminKubeconfig = "/etc/kubernetes/admin.conf"
+
+var recoverNodeName string
+
+func newRecoverCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "recover",
+		Short: "Renews the kubeadm certificates on the control plane host without API access, then reconciles the cluster state",
+		Run:   recoverNode,
+	}
+	cmd.Flags().StringVar(&recoverNodeName, "node-name", "",
+		"The node name, defaults to KUCERO_NODE_NAME environment variable or the hostname")
+	return cmd
+}
+
+// recoverNode renews the expiring kubeadm certificates and kubeconfigs locally,
+// restarts kubelet and the static pods, and only then connects to the apiserver
+// to reconcile the node status, the node cordon state and the lock
+func recoverNode(cmd *cobra.Command, args []string) {
+	logrus.Infof("KUbernetes CErtificate ROtation Recovery: %s", version)
+
+	nodeName := recoverNodeName
+	if nodeName == "" {
+		nodeName = os.Getenv("KUCERO_NODE_NAME")
+	}
+	if nodeName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		nodeName = hostname
+	}
+	logrus.Infof("Node Name: %s", nodeName)
+
+	// without API access, the static pods are checked by their health endpoints
+	certNode := node.New(host.DistributionKubeadm, true, nodeName, nil, newExpiryPolicy(), staticPodRestartTimeout, verifyTimeout, newKubeadmRenewal(), kubeadmConfigPath, enableKubeletClientCertRotation, enableKubeletServerCertRotation)
+	expiryCertificates, err := certNode.CheckExpiration()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	// the certificates renewed by an external CA are installed once signed
+	expiryCertificates, pending, rejected := certNode.SignedCertificates(expiryCertificates)
+	for _, rejection := range rejected {
+		logrus.Warnf("The external CA rejected the certificate %s request: %s %s", rejection.Name, rejection.Reason, rejection.Message)
+	}
+	if len(pending) > 0 {
+		logrus.Warnf("The certificates %v are not signed by the external CA yet", pending)
+		if err := certNode.RequestCertificates(pending); err != nil {
+			logrus.Error(err)
+		}
+	}
+
+	var rotateErr error
+	if len(expiryCertificates) == 0 {
+		logrus.Info("No certificate is going to expire")
+	} else {
+		logrus.Infof("The expiry certificiates are %v", expiryCertificates)
+		rotateErr = certNode.Rotate(expiryCertificates)
+		if rotateErr != nil {
+			logrus.Error(rotateErr)
+		}
+	}
+
+	if kubeconfig == "" && apiServerHost == "" {
+		kubeconfig = adminKubeconfig
+	}
+	config, err := clientcmd.BuildConfigFromFlags(apiServerHost, kubeconfig)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	corev1Node, err := reconcileRecoveredNode(client, nodeName, len(expiryCertificates) > 0, rotateErr)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	certNode = node.New(host.DistributionKubeadm, true, nodeName, client, newExpiryPolicy(), staticPodRestartTimeout, verifyTimeout, newKubeadmRenewal(), kubeadmConfigPath, enableKubeletClientCertRotation, enableKubeletServerCertRotation)
+	publishCertificateStatus(client, newRotationPlan(corev1Node, true, certNode, newRotationWindow(), false))
+
+	if rotateErr != nil {
+		logrus.Fatal(rotateErr)
+	}
+	logrus.Info("Recovery done")
+}
+
+// reconcileRecoveredNode waits for the node to be Ready, restores the node state saved by the rotation,
+// releases the rotation lock held by the node and publishes the rotation result if `rotated` is true
+// returns the reconciled node
+func reconcileRecoveredNode(client kubernetes.Interface, nodeName string, rotated bool, rotateErr error) (*corev1.Node, error) {
+	logrus.Info("Reconciling the cluster state")
+	if err := host.WaitForNodeReady(client, nodeName, verifyTimeout); err != nil {
+		return nil, err
+	}
+	corev1Node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if host.HasNodeState(corev1Node) {
+		_ = host.RestoreNodeState(client, nodeName)
+	}
+	rotationLock := lock.NewSemaphore(client, dsNamespace, lockName+"-control-plane", nodeName, 1, lockDuration)
+	if holding(rotationLock) {
+		release(rotationLock)
+	}
+
+	if rotated {
+		publishRotationResult(client, nodeName, rotateErr)
+	}
+	return corev1Node, nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/lock"
+)
+
+func Test_reconcileRecoveredNode(t *testing.T) {
+	dsNamespace, lockName, lockDuration, verifyTimeout = "kube-system", "kucero", time.Minute, 100*time.Millisecond
+
+	tests := []struct {
+		name           string
+		ready          corev1.ConditionStatus
+		interrupted    bool
+		rotated        bool
+		rotateErr      error
+		expectedErr    bool
+		expectedResult string
+	}{
+		{
+			name:           "rotation interrupted while the node was cordoned",
+			ready:          corev1.ConditionTrue,
+			interrupted:    true,
+			rotated:        true,
+			expectedResult: "Succeeded",
+		},
+		{
+			name:           "rotation failed",
+			ready:          corev1.ConditionTrue,
+			interrupted:    true,
+			rotated:        true,
+			rotateErr:      errors.New("kubelet is not active"),
+			expectedResult: "Failed: kubelet is not active",
+		},
+		{
+			name:  "no certificate renewed",
+			ready: corev1.ConditionTrue,
+		},
+		{
+			name:        "node not Ready",
+			ready:       corev1.ConditionFalse,
+			interrupted: true,
+			rotated:     true,
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			n := &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "master-1", Annotations: map[string]string{}},
+				Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: tt.ready}}},
+			}
+			if tt.interrupted {
+				n.Annotations[host.NodeStateAnnotation] = `{"unschedulable":false}`
+				n.Annotations[host.CordonReasonAnnotation] = "certificate rotation"
+				n.Spec.Unschedulable = true
+				n.Spec.Taints = []corev1.Taint{{Key: host.CordonTaint, Effect: corev1.TaintEffectNoSchedule}}
+			}
+			client := fake.NewSimpleClientset(n)
+
+			rotationLock := lock.NewSemaphore(client, dsNamespace, lockName+"-control-plane", "master-1", 1, lockDuration)
+			if tt.interrupted && !acquire(rotationLock, "abcde") {
+				t.Fatal("expected the rotation lock acquired")
+			}
+
+			_, err := reconcileRecoveredNode(client, "master-1", tt.rotated, tt.rotateErr)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("expected error %t, got %v", tt.expectedErr, err)
+			}
+			if tt.expectedErr {
+				return
+			}
+
+			got, err := client.CoreV1().Nodes().Get(context.TODO(), "master-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Spec.Unschedulable || len(got.Spec.Taints) != 0 || host.HasNodeState(got) {
+				t.Errorf("expected the node state restored, got unschedulable %t taints %v annotations %v", got.Spec.Unschedulable, got.Spec.Taints, got.Annotations)
+			}
+			if result := got.GetAnnotations()[host.LastRotationResultAnnotation]; result != tt.expectedResult {
+				t.Errorf("expected rotation result %q, got %q", tt.expectedResult, result)
+			}
+			if holding, holder, err := rotationLock.Holding(); err != nil || holding {
+				t.Errorf("expected the rotation lock released, got holder %q, error %v", holder, err)
+			}
+		})
+	}
+}
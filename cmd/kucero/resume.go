@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jenting/kucero/pkg/checkpoint"
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/lock"
+	"github.com/jenting/kucero/pkg/pki/node"
+)
+
+// resumeRotation finishes the rotation interrupted by a kucero restart:
+// restarts kubelet and the components using the renewed certificates,
+// restores the node cordon state, marks the rotation failed and releases the lock
+func resumeRotation(client *kubernetes.Clientset, recordClient ctrlclient.Client, corev1Node *corev1.Node, certNode *node.Node, rotationLock *lock.Semaphore) {
+	nodeName := corev1Node.GetName()
+
+	c, err := checkpoint.Load(checkpointPath)
+	if err != nil {
+		logrus.Errorf("Error loading checkpoint %s: %v", checkpointPath, err)
+	}
+	if c != nil {
+		logrus.Infof("Resuming rotation %s interrupted at phase %s", c.RotationID, c.LastPhase())
+		record := resumeRotationRecord(recordClient, c)
+
+		errs := fmt.Errorf("rotation interrupted at phase %s", c.LastPhase())
+		if c.Started(phaseUpdateConfig, phaseRenew, phaseVerify, phaseRollback) {
+			if err := host.RestartService(nodeName, certNode.Service); err != nil {
+				errs = errors.Join(errs, err)
+			}
+		}
+		if c.Started(phaseRenew, phaseVerify, phaseRollback) {
+			if err := certNode.Restart(c.Certificates); err != nil {
+				errs = errors.Join(errs, err)
+			}
+		}
+		if host.HasNodeState(corev1Node) {
+			if err := restoreNodeState(client, nodeName, record); err != nil {
+				errs = errors.Join(errs, err)
+			}
+		}
+
+		record.complete(nil, errs)
+		publishRotationResult(client, nodeName, errs)
+	}
+
+	// restores the node cordon state saved by a rotation without checkpoint
+	if c == nil && host.HasNodeState(corev1Node) {
+		_ = host.RestoreNodeState(client, nodeName)
+	}
+	if holding(rotationLock) {
+		release(rotationLock)
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/metrics"
+	"github.com/jenting/kucero/pkg/pki/node"
+)
+
+// rotate cordons and drains the node, updates the kubelet configuration,
+// rotates the certificates and restores the node cordon state
+// the rotation is aborted and the node cordon state restored if cordon or drain fails
+func rotate(client *kubernetes.Clientset, nodeName string, certNode *node.Node, plan *rotationPlan, record *rotationRecord) error {
+	var errs error
+
+	// re-fetches the node to act on its current cordon state
+	corev1Node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		err = fmt.Errorf("aborting rotation: %w", err)
+		record.complete(certNode.Backups(), err)
+		return err
+	}
+	state, err := host.SaveNodeState(client, corev1Node)
+	if err != nil {
+		err = fmt.Errorf("aborting rotation: %w", err)
+		record.complete(certNode.Backups(), err)
+		return err
+	}
+
+	if plan.Cordon && !state.Unschedulable {
+		record.startPhase(phaseCordon)
+		start := time.Now()
+		err := host.Cordon(client, corev1Node)
+		if err == nil {
+			err = host.SetCordonReason(client, nodeName)
+		}
+		metrics.ObservePhase(nodeName, metrics.PhaseCordon, start)
+		record.endPhase(phaseCordon, err)
+		if err != nil {
+			errs = fmt.Errorf("aborting rotation: %w", err)
+			if err := restoreNodeState(client, nodeName, record); err != nil {
+				errs = errors.Join(errs, err)
+			}
+			record.complete(certNode.Backups(), errs)
+			return errs
+		}
+	}
+
+	if plan.Drain {
+		record.startPhase(phaseDrain)
+		start := time.Now()
+		err := host.Drain(client, corev1Node, drainOptions())
+		metrics.ObservePhase(nodeName, metrics.PhaseDrain, start)
+		record.endPhase(phaseDrain, err)
+		if err != nil {
+			errs = fmt.Errorf("aborting rotation: %w", err)
+			if err := restoreNodeState(client, nodeName, record); err != nil {
+				errs = errors.Join(errs, err)
+			}
+			record.complete(certNode.Backups(), errs)
+			return errs
+		}
+	}
+
+	configsToBeUpdate := plan.configPaths()
+	if len(configsToBeUpdate) > 0 {
+		logrus.Infof("The configuration need to be updates are %v", configsToBeUpdate)
+
+		logrus.Info("Waiting for configuration to be update")
+		record.startPhase(phaseUpdateConfig)
+		err := certNode.UpdateConfig(configsToBeUpdate)
+		if err != nil {
+			logrus.Error(err)
+			errs = errors.Join(errs, err)
+		}
+		record.endPhase(phaseUpdateConfig, err)
+		logrus.Info("Update configuration done")
+	}
+
+	if len(plan.Certificates) > 0 {
+		logrus.Infof("The expiry certificiates are %v", plan.Certificates)
+
+		logrus.Info("Waiting for certificate rotation")
+		record.startPhase(phaseRenew)
+		start := time.Now()
+		err := certNode.Rotate(plan.Certificates)
+		if err != nil {
+			logrus.Error(err)
+			errs = errors.Join(errs, err)
+		}
+		metrics.ObservePhase(nodeName, metrics.PhaseRenew, start)
+		record.endPhase(phaseRenew, err)
+		logrus.Info("Certificate rotation done")
+
+		if err == nil {
+			record.startPhase(phaseVerify)
+			start = time.Now()
+			err = verify(client, nodeName, certNode)
+			if err != nil {
+				logrus.Error(err)
+				errs = errors.Join(errs, err)
+			}
+			metrics.ObservePhase(nodeName, metrics.PhaseVerify, start)
+			record.endPhase(phaseVerify, err)
+		}
+
+		// restores the backed up certificates if the rotation is unhealthy
+		if err != nil {
+			logrus.Info("Rolling back certificate rotation")
+			record.startPhase(phaseRollback)
+			start = time.Now()
+			err = certNode.Rollback()
+			if err != nil {
+				logrus.Error(err)
+				errs = errors.Join(errs, err)
+			}
+			metrics.ObservePhase(nodeName, metrics.PhaseRollback, start)
+			record.endPhase(phaseRollback, err)
+		}
+	}
+
+	if plan.Uncordon {
+		if err := restoreNodeState(client, nodeName, record); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+
+	record.complete(certNode.Backups(), errs)
+	return errs
+}
+
+// restoreNodeState restores the node cordon state saved before the rotation,
+// the node is uncordoned unless it was cordoned before the rotation
+func restoreNodeState(client *kubernetes.Clientset, nodeName string, record *rotationRecord) error {
+	record.startPhase(phaseUncordon)
+	err := host.RestoreNodeState(client, nodeName)
+	record.endPhase(phaseUncordon, err)
+	return err
+}
+
+// drainOptions returns the node drain options from the command line flags
+func drainOptions() host.DrainOptions {
+	return host.DrainOptions{
+		Timeout:            drainTimeout,
+		GracePeriodSeconds: drainGracePeriod,
+		PodSelector:        drainPodSelector,
+		DisableEviction:    drainDisableEviction,
+	}
+}
+
+// verify waits for kubelet to be active and the node to be Ready,
+// then verifies the node serves the rotated certificates
+func verify(client *kubernetes.Clientset, nodeName string, certNode *node.Node) error {
+	if err := host.WaitForServiceActive(nodeName, certNode.Service, verifyTimeout); err != nil {
+		return err
+	}
+	if err := host.WaitForNodeReady(client, nodeName, verifyTimeout); err != nil {
+		return err
+	}
+	return certNode.Verify(verifyTimeout)
+}
+
+// observeCertificateStatus reports the certificate check result as metrics
+func observeCertificateStatus(plan *rotationPlan) {
+	metrics.SetCertificateExpiry(plan.Node, plan.infos)
+	metrics.SetKubeletConfigDrift(plan.Node, plan.configPaths())
+	metrics.SetCertificateDrift(plan.Node, plan.Drifted)
+	metrics.SetCertificateRequestRejected(plan.Node, plan.Rejected)
+}
+
+// publishCertificateStatus publishes the certificate check result
+// to the node condition and annotation
+func publishCertificateStatus(client kubernetes.Interface, plan *rotationPlan) {
+	observeCertificateStatus(plan)
+
+	var earliestExpiry time.Time
+	if plan.EarliestExpiry != nil {
+		earliestExpiry = *plan.EarliestExpiry
+	}
+	_ = host.SetCertificateCondition(client, plan.Node, plan.expiring, plan.driftedCertificates(), plan.rejectedCertificates(), earliestExpiry)
+	_ = host.Annotate(client, plan.Node, map[string]string{
+		host.LastCheckTimeAnnotation: time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// publishRotationResult publishes the certificate rotation result
+// to the node annotation
+func publishRotationResult(client kubernetes.Interface, nodeName string, err error) {
+	metrics.ObserveRotation(nodeName, err)
+
+	result := "Succeeded"
+	if err != nil {
+		result = fmt.Sprintf("Failed: %v", err)
+	}
+	_ = host.Annotate(client, nodeName, map[string]string{
+		host.LastRotationTimeAnnotation:   time.Now().UTC().Format(time.RFC3339),
+		host.LastRotationResultAnnotation: result,
+	})
+}
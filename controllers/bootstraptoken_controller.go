@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/pki/cert/kubelet"
+)
+
+// bootstrapTokenRequeueAfter is the delay before checking again
+// a node still Ready when it requests a bootstrap token
+const bootstrapTokenRequeueAfter = 10 * time.Second
+
+// BootstrapTokenReconciler issues the bootstrap tokens requested by the nodes
+// whose kubelet client certificate expired, it runs on the leader control plane node
+// so the worker nodes do not need to create bootstrap token Secrets
+type BootstrapTokenReconciler struct {
+	ClientSet     k8sclient.Interface
+	EventRecorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=create
+
+func (r *BootstrapTokenReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	node, err := r.ClientSet.CoreV1().Nodes().Get(ctx, req.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	request := node.GetAnnotations()[kubelet.BootstrapTokenRequestAnnotation]
+	if request == "" || node.GetAnnotations()[kubelet.BootstrapTokenAnnotation] != "" {
+		return ctrl.Result{}, nil
+	}
+	// a node with a valid client certificate does not need to re-bootstrap,
+	// the node status may lag behind the certificate expiry
+	if isNodeReady(node) {
+		logrus.Infof("The %s node requesting a bootstrap token is Ready, checking again in %v", node.Name, bootstrapTokenRequeueAfter)
+		return ctrl.Result{RequeueAfter: bootstrapTokenRequeueAfter}, nil
+	}
+
+	encrypted, err := kubelet.IssueBootstrapToken(r.ClientSet, node.Name, request, time.Now())
+	if err != nil {
+		r.EventRecorder.Event(node, corev1.EventTypeWarning, "BootstrapTokenFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+	if err := host.Annotate(r.ClientSet, node.Name, map[string]string{kubelet.BootstrapTokenAnnotation: encrypted}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	logrus.Infof("Issued a bootstrap token for %s node", node.Name)
+	r.EventRecorder.Event(node, corev1.EventTypeNormal, "BootstrapTokenIssued", "A bootstrap token is issued to re-bootstrap the node")
+	return ctrl.Result{}, nil
+}
+
+// isNodeReady checks the node Ready condition is true
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func (r *BootstrapTokenReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	requested := predicate.NewPredicateFuncs(func(o client.Object) bool {
+		_, ok := o.GetAnnotations()[kubelet.BootstrapTokenRequestAnnotation]
+		return ok
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Node{}, builder.WithPredicates(requested)).
+		Complete(r)
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/jenting/kucero/pkg/pki/cert/kubelet"
+)
+
+func TestBootstrapTokenReconciler(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := base64.StdEncoding.EncodeToString(der)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		ready       corev1.ConditionStatus
+		expectToken bool
+		expectRetry bool
+	}{
+		{
+			name:        "NotReady node requesting a token",
+			annotations: map[string]string{kubelet.BootstrapTokenRequestAnnotation: request},
+			ready:       corev1.ConditionUnknown,
+			expectToken: true,
+		},
+		{
+			name:        "Ready node requesting a token",
+			annotations: map[string]string{kubelet.BootstrapTokenRequestAnnotation: request},
+			ready:       corev1.ConditionTrue,
+			expectRetry: true,
+		},
+		{
+			name:  "NotReady node without request",
+			ready: corev1.ConditionUnknown,
+		},
+		{
+			name:        "token already issued",
+			annotations: map[string]string{kubelet.BootstrapTokenRequestAnnotation: request, kubelet.BootstrapTokenAnnotation: "issued"},
+			ready:       corev1.ConditionUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "worker", Annotations: tt.annotations},
+				Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: tt.ready}}},
+			})
+			r := &BootstrapTokenReconciler{ClientSet: client, EventRecorder: record.NewFakeRecorder(10)}
+
+			result, err := r.Reconcile(context.TODO(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "worker"}})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if (result.RequeueAfter > 0) != tt.expectRetry {
+				t.Errorf("expected retry %v, got %v", tt.expectRetry, result)
+			}
+
+			secrets, err := client.CoreV1().Secrets("kube-system").List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			node, err := client.CoreV1().Nodes().Get(context.TODO(), "worker", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.expectToken {
+				if len(secrets.Items) != 0 {
+					t.Errorf("expected no bootstrap token, got %v", secrets.Items)
+				}
+				return
+			}
+			if len(secrets.Items) != 1 {
+				t.Fatalf("expected one bootstrap token, got %v", secrets.Items)
+			}
+			// only the requesting node decrypts the token
+			ciphertext, err := base64.StdEncoding.DecodeString(node.GetAnnotations()[kubelet.BootstrapTokenAnnotation])
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := rsa.DecryptOAEP(sha256.New(), nil, key, ciphertext, []byte("kucero-bootstrap-token"))
+			if err != nil {
+				t.Fatalf("expected the token encrypted with the requested key, got %v", err)
+			}
+			data := secrets.Items[0].StringData
+			if expected := data["token-id"] + "." + data["token-secret"]; string(token) != expected {
+				t.Errorf("expected token %q, got %q", expected, token)
+			}
+			if data["usage-bootstrap-authentication"] != "true" || data["usage-bootstrap-signing"] != "" {
+				t.Errorf("expected an authentication only bootstrap token, got %v", data)
+			}
+		})
+	}
+}
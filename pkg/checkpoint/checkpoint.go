@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Checkpoint is the progress of a rotation persisted on the host,
+// so a rotation interrupted by a kucero restart can be finished
+type Checkpoint struct {
+	RotationID   string   `json:"rotationID"`
+	Node         string   `json:"node"`
+	Certificates []string `json:"certificates,omitempty"`
+	Configs      []string `json:"configs,omitempty"`
+	Phases       []Phase  `json:"phases,omitempty"`
+}
+
+// Phase is a rotation phase
+type Phase struct {
+	Name      string    `json:"name"`
+	StartTime time.Time `json:"startTime"`
+	Completed bool      `json:"completed"`
+}
+
+// StartPhase records the phase start
+func (c *Checkpoint) StartPhase(name string, now time.Time) {
+	c.Phases = append(c.Phases, Phase{Name: name, StartTime: now})
+}
+
+// EndPhase records the phase completion
+func (c *Checkpoint) EndPhase(name string) {
+	for i := range c.Phases {
+		if c.Phases[i].Name == name {
+			c.Phases[i].Completed = true
+		}
+	}
+}
+
+// Started checks if any of the phases is started
+func (c *Checkpoint) Started(names ...string) bool {
+	for _, phase := range c.Phases {
+		for _, name := range names {
+			if phase.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LastPhase returns the last started phase
+func (c *Checkpoint) LastPhase() string {
+	if len(c.Phases) == 0 {
+		return ""
+	}
+	return c.Phases[len(c.Phases)-1].Name
+}
+
+// Save writes the checkpoint file atomically
+func Save(path string, c *Checkpoint) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads the checkpoint file
+// returns nil if there is no checkpoint file
+func Load(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Checkpoint{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Remove removes the checkpoint file
+func Remove(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package checkpoint
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kucero", "checkpoint.json")
+
+	c, err := Load(path)
+	if err != nil || c != nil {
+		t.Fatalf("expected no checkpoint, got %v %v", c, err)
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	expected := &Checkpoint{
+		RotationID:   "abcde",
+		Node:         "master-0",
+		Certificates: []string{"apiserver"},
+	}
+	expected.StartPhase("cordon", now)
+	expected.EndPhase("cordon")
+	expected.StartPhase("renew", now)
+	if err := Save(path, expected); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	c, err = Load(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("expected %v, got %v", expected, c)
+	}
+	if !c.Started("update-config", "renew") || c.Started("uncordon") {
+		t.Errorf("expected renew started and uncordon not started, got %v", c.Phases)
+	}
+	if c.LastPhase() != "renew" {
+		t.Errorf("expected last phase renew, got %s", c.LastPhase())
+	}
+
+	if err := Remove(path); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c, err := Load(path); err != nil || c != nil {
+		t.Errorf("expected no checkpoint after remove, got %v %v", c, err)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Root is the host root file system seen from the kucero pod,
+	// relies on hostPID:true and privileged:true
+	Root = "/proc/1/root"
+
+	// DistributionAuto detects the distribution on the host system
+	DistributionAuto = "auto"
+	// DistributionKubeadm is a kubeadm provisioned node
+	DistributionKubeadm = "kubeadm"
+	// DistributionK3s is a k3s node
+	DistributionK3s = "k3s"
+	// DistributionRKE2 is a RKE2 node
+	DistributionRKE2 = "rke2"
+
+	// rancherPath is the PATH the k3s or RKE2 binary is looked up in,
+	// the install script puts it in /usr/local/bin, or /opt/rke2/bin if /usr/local is read-only,
+	// the RPM packages in /usr/bin
+	rancherPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/opt/rke2/bin"
+)
+
+// RancherDataDir returns the k3s or RKE2 data folder on the host system
+func RancherDataDir(distribution string) string {
+	return filepath.Join("/var/lib/rancher", distribution)
+}
+
+// RancherBinary returns the path of the k3s or RKE2 binary on the host system
+func RancherBinary(distribution string) (string, error) {
+	// Relies on hostPID:true and privileged:true to enter host mount space
+	cmd := NewCommandWithStdout("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/bin/sh", "-c", `command -v "$0"`, distribution)
+	cmd.Env = []string{"PATH=" + rancherPath}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s binary not found on the host system: %w", distribution, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// DetectDistribution detects the Kubernetes distribution of the node
+// by the k3s or RKE2 data folder on the host system, kubeadm otherwise
+func DetectDistribution() string {
+	// RKE2 is built on k3s, checks it first
+	for _, distribution := range []string{DistributionRKE2, DistributionK3s} {
+		for _, dir := range []string{"server", "agent"} {
+			if _, err := os.Stat(filepath.Join(Root, RancherDataDir(distribution), dir)); err == nil {
+				return distribution
+			}
+		}
+	}
+	return DistributionKubeadm
+}
+
+// ValidateDistribution validates the distribution
+func ValidateDistribution(distribution string) error {
+	switch distribution {
+	case DistributionAuto, DistributionKubeadm, DistributionK3s, DistributionRKE2:
+		return nil
+	default:
+		return fmt.Errorf("invalid distribution %q, must be one of %s, %s, %s, %s", distribution, DistributionAuto, DistributionKubeadm, DistributionK3s, DistributionRKE2)
+	}
+}
+
+// KubeletService returns the systemd unit running kubelet on the node
+func KubeletService(distribution string, isControlPlane bool) string {
+	switch {
+	case distribution == DistributionK3s && isControlPlane:
+		return "k3s"
+	case distribution == DistributionK3s:
+		return "k3s-agent"
+	case distribution == DistributionRKE2 && isControlPlane:
+		return "rke2-server"
+	case distribution == DistributionRKE2:
+		return "rke2-agent"
+	default:
+		return "kubelet"
+	}
+}
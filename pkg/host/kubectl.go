@@ -18,7 +18,9 @@ package host
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
@@ -43,6 +45,7 @@ func Uncordon(client *kubernetes.Clientset, corev1Node *corev1.Node) error {
 	// The desired value "false" is passed to "Unschedulable" to indicate that the node is schedulable.
 	if err := kubectldrain.RunCordonOrUncordon(drainer, corev1Node, false); err != nil {
 		logrus.Errorf("Error uncordonning %s: %v", nodeName, err)
+		return fmt.Errorf("uncordon %s: %w", nodeName, err)
 	}
 	return nil
 }
@@ -63,27 +66,51 @@ func Cordon(client *kubernetes.Clientset, corev1Node *corev1.Node) error {
 	// The desired value "true" is passed to "Unschedulable" to indicate that the node is unschedulable.
 	if err := kubectldrain.RunCordonOrUncordon(drainer, corev1Node, true); err != nil {
 		logrus.Errorf("Error cordonning %s: %v", nodeName, err)
+		return fmt.Errorf("cordon %s: %w", nodeName, err)
 	}
 	return nil
 }
 
-// Drain executes `kubectl drain --ignore-daemonsets --delete-local-data --force <node-name>`
+// DrainOptions configures the node drain
+type DrainOptions struct {
+	// Timeout is the time to wait before giving up, zero means infinite
+	Timeout time.Duration
+	// GracePeriodSeconds is the pod termination grace period,
+	// negative uses the pod default
+	GracePeriodSeconds int
+	// PodSelector only evicts the pods matching the label selector
+	PodSelector string
+	// DisableEviction deletes the pods instead of evicting them,
+	// which bypasses the PodDisruptionBudgets
+	DisableEviction bool
+}
+
+// Drain executes `kubectl drain --ignore-daemonsets --delete-emptydir-data --force <node-name>`
 // on the host system
-func Drain(client *kubernetes.Clientset, corev1Node *corev1.Node) error {
+func Drain(client kubernetes.Interface, corev1Node *corev1.Node, opts DrainOptions) error {
 	nodeName := corev1Node.GetName()
 	logrus.Infof("Draining %s node", nodeName)
 
-	drainer := &kubectldrain.Helper{
+	if err := kubectldrain.RunNodeDrain(newDrainer(client, opts), nodeName); err != nil {
+		logrus.Errorf("Error draining %s: %v", nodeName, err)
+		return fmt.Errorf("drain %s: %w", nodeName, err)
+	}
+	return nil
+}
+
+// newDrainer returns the kubectl drain helper configured with the drain options
+func newDrainer(client kubernetes.Interface, opts DrainOptions) *kubectldrain.Helper {
+	return &kubectldrain.Helper{
 		Ctx:                 context.TODO(),
 		Client:              client,
 		Force:               true,
 		DeleteEmptyDirData:  true,
 		IgnoreAllDaemonSets: true,
+		Timeout:             opts.Timeout,
+		GracePeriodSeconds:  opts.GracePeriodSeconds,
+		PodSelector:         opts.PodSelector,
+		DisableEviction:     opts.DisableEviction,
 		Out:                 os.Stdout,
 		ErrOut:              os.Stderr,
 	}
-	if err := kubectldrain.RunNodeDrain(drainer, nodeName); err != nil {
-		logrus.Errorf("Error draining %s: %v", nodeName, err)
-	}
-	return nil
 }
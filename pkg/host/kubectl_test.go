@@ -0,0 +1,134 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_newDrainer(t *testing.T) {
+	tests := []struct {
+		name string
+		opts DrainOptions
+	}{
+		{
+			name: "default options",
+			opts: DrainOptions{GracePeriodSeconds: -1},
+		},
+		{
+			name: "custom options",
+			opts: DrainOptions{
+				Timeout:            5 * time.Minute,
+				GracePeriodSeconds: 30,
+				PodSelector:        "app!=critical",
+				DisableEviction:    true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			drainer := newDrainer(fake.NewSimpleClientset(), tt.opts)
+			if drainer.Timeout != tt.opts.Timeout {
+				t.Errorf("expected timeout %v, got %v", tt.opts.Timeout, drainer.Timeout)
+			}
+			if drainer.GracePeriodSeconds != tt.opts.GracePeriodSeconds {
+				t.Errorf("expected grace period %d, got %d", tt.opts.GracePeriodSeconds, drainer.GracePeriodSeconds)
+			}
+			if drainer.PodSelector != tt.opts.PodSelector {
+				t.Errorf("expected pod selector %q, got %q", tt.opts.PodSelector, drainer.PodSelector)
+			}
+			if drainer.DisableEviction != tt.opts.DisableEviction {
+				t.Errorf("expected disable eviction %t, got %t", tt.opts.DisableEviction, drainer.DisableEviction)
+			}
+			// `kubectl drain --ignore-daemonsets --delete-emptydir-data --force`
+			if !drainer.Force || !drainer.DeleteEmptyDirData || !drainer.IgnoreAllDaemonSets {
+				t.Errorf("expected force, delete emptydir data and ignore daemonsets, got %t %t %t", drainer.Force, drainer.DeleteEmptyDirData, drainer.IgnoreAllDaemonSets)
+			}
+		})
+	}
+}
+
+func TestDrain(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+	}
+
+	tests := []struct {
+		name            string
+		opts            DrainOptions
+		listErr         error
+		expectedErr     string
+		expectedDeleted bool
+	}{
+		{
+			name:            "pods deleted",
+			opts:            DrainOptions{GracePeriodSeconds: -1, DisableEviction: true},
+			expectedDeleted: true,
+		},
+		{
+			name:        "invalid pod selector",
+			opts:        DrainOptions{GracePeriodSeconds: -1, PodSelector: "app in (", DisableEviction: true},
+			expectedErr: "drain node-1",
+		},
+		{
+			name:        "listing pods failed",
+			opts:        DrainOptions{GracePeriodSeconds: -1, DisableEviction: true},
+			listErr:     apierrors.NewForbidden(corev1.Resource("pods"), "", errors.New("denied")),
+			expectedErr: "denied",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(node.DeepCopy(), pod.DeepCopy())
+			if tt.listErr != nil {
+				client.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.listErr
+				})
+			}
+
+			err := Drain(client, node, tt.opts)
+			if tt.expectedErr == "" && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tt.expectedErr)) {
+				t.Fatalf("expected error containing %q, got %v", tt.expectedErr, err)
+			}
+
+			_, err = client.CoreV1().Pods("default").Get(context.TODO(), "pod-1", metav1.GetOptions{})
+			if deleted := apierrors.IsNotFound(err); deleted != tt.expectedDeleted {
+				t.Errorf("expected pod deleted %t, got %v", tt.expectedDeleted, err)
+			}
+		})
+	}
+}
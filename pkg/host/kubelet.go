@@ -16,18 +16,51 @@ limitations under the License.
 
 package host
 
-import "github.com/sirupsen/logrus"
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jenting/kucero/pkg/metrics"
+)
 
 // RestartKubelet executes `systemctl restart kubelet`
 // on the host system
 func RestartKubelet(nodeName string) error {
-	logrus.Infof("Commanding restart kubelet on %s node", nodeName)
+	return RestartService(nodeName, "kubelet")
+}
+
+// RestartService executes `systemctl restart <service>`
+// on the host system
+func RestartService(nodeName, service string) error {
+	logrus.Infof("Commanding restart %s on %s node", service, nodeName)
+
+	start := time.Now()
+	defer metrics.ObservePhase(nodeName, metrics.PhaseRestart, start)
+
+	return systemctl(nodeName, "restart", service)
+}
+
+// StopService executes `systemctl stop <service>`
+// on the host system
+func StopService(nodeName, service string) error {
+	logrus.Infof("Commanding stop %s on %s node", service, nodeName)
+	return systemctl(nodeName, "stop", service)
+}
+
+// StartService executes `systemctl start <service>`
+// on the host system
+func StartService(nodeName, service string) error {
+	logrus.Infof("Commanding start %s on %s node", service, nodeName)
+	return systemctl(nodeName, "start", service)
+}
 
+func systemctl(nodeName, action, service string) error {
 	// Relies on hostPID:true and privileged:true to enter host mount space
-	cmd := NewCommand("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/systemctl", "restart", "kubelet")
+	cmd := NewCommand("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/systemctl", action, service)
 	err := cmd.Run()
 	if err != nil {
-		logrus.Errorf("Error invoking %s: %v", cmd.Args, err)
+		logrus.Errorf("Error invoking %s on %s node: %v", cmd.Args, nodeName, err)
 	}
 
 	return err
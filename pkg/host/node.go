@@ -0,0 +1,141 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// CertificatesExpiringSoon is the node condition type
+	// reporting the node certificates which are going to expires
+	CertificatesExpiringSoon corev1.NodeConditionType = "CertificatesExpiringSoon"
+
+	// LastCheckTimeAnnotation records the last certificate check time
+	LastCheckTimeAnnotation = "caasp.suse.com/kucero-last-check-time"
+	// LastRotationTimeAnnotation records the last certificate rotation time
+	LastRotationTimeAnnotation = "caasp.suse.com/kucero-last-rotation-time"
+	// LastRotationResultAnnotation records the last certificate rotation result
+	LastRotationResultAnnotation = "caasp.suse.com/kucero-last-rotation-result"
+)
+
+// SetCertificateCondition sets the CertificatesExpiringSoon node condition
+// with the certificates which are going to expires and the earliest expiry time,
+// the certificates whose identity drifted or whose request the external CA rejected
+// are reported with a distinct reason
+func SetCertificateCondition(client kubernetes.Interface, nodeName string, expiryCertificates, driftedCertificates, rejectedCertificates []string, earliestExpiry time.Time) error {
+	condition := corev1.NodeCondition{
+		Type:              CertificatesExpiringSoon,
+		Status:            corev1.ConditionFalse,
+		LastHeartbeatTime: metav1.Now(),
+		Reason:            "CertificatesValid",
+		Message:           "The node certificates are valid",
+	}
+	if !earliestExpiry.IsZero() {
+		condition.Message = fmt.Sprintf("The node certificates are valid, the earliest expires at %s", earliestExpiry.UTC().Format(time.RFC3339))
+	}
+	if len(driftedCertificates) > 0 {
+		condition.Reason = "CertificatesDrifted"
+		condition.Message = fmt.Sprintf("The node certificates %s drifted from the desired identity and are going to be reissued",
+			strings.Join(driftedCertificates, ", "))
+	}
+	if len(expiryCertificates) > 0 {
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = "CertificatesExpiring"
+		condition.Message = fmt.Sprintf("The node certificates %s are going to expire, the earliest expires at %s",
+			strings.Join(expiryCertificates, ", "), earliestExpiry.UTC().Format(time.RFC3339))
+	}
+	if len(rejectedCertificates) > 0 {
+		condition.Reason = "CertificateRequestsRejected"
+		condition.Message = fmt.Sprintf("%s, the external CA rejected the certificate requests of %s and they are requested again",
+			condition.Message, strings.Join(rejectedCertificates, ", "))
+	}
+
+	corev1Node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	condition.LastTransitionTime = condition.LastHeartbeatTime
+	for _, c := range corev1Node.Status.Conditions {
+		if c.Type == CertificatesExpiringSoon && c.Status == condition.Status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []corev1.NodeCondition{condition},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Nodes().PatchStatus(context.TODO(), nodeName, patch)
+	if err != nil {
+		logrus.Errorf("Error setting %s node condition %s: %v", nodeName, CertificatesExpiringSoon, err)
+	}
+	return err
+}
+
+// Annotate sets the node annotations
+func Annotate(client kubernetes.Interface, nodeName string, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		logrus.Errorf("Error annotating %s node: %v", nodeName, err)
+	}
+	return err
+}
+
+// RemoveAnnotations removes the node annotations
+func RemoveAnnotations(client kubernetes.Interface, nodeName string, keys ...string) error {
+	annotations := map[string]interface{}{}
+	for _, key := range keys {
+		annotations[key] = nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		logrus.Errorf("Error removing %s node annotations %v: %v", nodeName, keys, err)
+	}
+	return err
+}
@@ -0,0 +1,207 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// NodeStateAnnotation records the node state before the rotation,
+	// the annotation is removed once the node state is restored
+	NodeStateAnnotation = "caasp.suse.com/kucero-node-state"
+
+	// CordonReasonAnnotation records why kucero cordoned the node
+	CordonReasonAnnotation = "caasp.suse.com/kucero-cordon-reason"
+	// CordonTaint is the taint kucero sets on the node it cordoned
+	CordonTaint = "caasp.suse.com/kucero-rotation"
+
+	// cordonReason is the reason kucero cordons the node
+	cordonReason = "certificate rotation"
+
+	// kuceroAnnotationPrefix prefixes the annotations kucero owns
+	kuceroAnnotationPrefix = "caasp.suse.com/kucero-"
+)
+
+// NodeState is the node cordon state captured before the rotation
+type NodeState struct {
+	Unschedulable bool `json:"unschedulable"`
+	// Taints and Annotations record why the node was cordoned before the rotation,
+	// e.g. node.kubernetes.io/unschedulable and the cordon reason of another tool
+	Taints      []corev1.Taint    `json:"taints,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// SaveNodeState records the node state in the node annotation
+// the state recorded by an interrupted rotation is kept and returned
+func SaveNodeState(client kubernetes.Interface, corev1Node *corev1.Node) (*NodeState, error) {
+	nodeName := corev1Node.GetName()
+	state, ok, err := nodeState(corev1Node)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		logrus.Infof("Using %s node state recorded by the interrupted rotation", nodeName)
+		return state, nil
+	}
+
+	state = &NodeState{Unschedulable: corev1Node.Spec.Unschedulable}
+	if state.Unschedulable {
+		for _, taint := range corev1Node.Spec.Taints {
+			if taint.Key != CordonTaint {
+				state.Taints = append(state.Taints, taint)
+			}
+		}
+		for k, v := range corev1Node.GetAnnotations() {
+			if !strings.HasPrefix(k, kuceroAnnotationPrefix) && k != corev1.LastAppliedConfigAnnotation {
+				if state.Annotations == nil {
+					state.Annotations = map[string]string{}
+				}
+				state.Annotations[k] = v
+			}
+		}
+		logrus.Infof("The %s node is already cordoned, keeping its taints and annotations", nodeName)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("Saving %s node state %s", nodeName, data)
+	if err := Annotate(client, nodeName, map[string]string{NodeStateAnnotation: string(data)}); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// HasNodeState checks if the node state is recorded by a rotation
+func HasNodeState(corev1Node *corev1.Node) bool {
+	_, ok := corev1Node.GetAnnotations()[NodeStateAnnotation]
+	return ok
+}
+
+// SetCordonReason sets the kucero cordon reason annotation and taint
+// on the node kucero cordoned, the other annotations and taints are kept as is
+func SetCordonReason(client kubernetes.Interface, nodeName string) error {
+	err := updateNode(client, nodeName, func(corev1Node *corev1.Node) {
+		if corev1Node.Annotations == nil {
+			corev1Node.Annotations = map[string]string{}
+		}
+		corev1Node.Annotations[CordonReasonAnnotation] = cordonReason
+		for _, taint := range corev1Node.Spec.Taints {
+			if taint.Key == CordonTaint {
+				return
+			}
+		}
+		corev1Node.Spec.Taints = append(corev1Node.Spec.Taints, corev1.Taint{Key: CordonTaint, Effect: corev1.TaintEffectNoSchedule})
+	})
+	if err != nil {
+		logrus.Errorf("Error setting %s node cordon reason: %v", nodeName, err)
+	}
+	return err
+}
+
+// RestoreNodeState restores the node schedulable recorded in the node annotation,
+// and the taints and annotations of the node cordoned before the rotation if they are removed,
+// removes the kucero cordon reason annotation and taint, then removes the node annotation,
+// the annotations and taints added or changed by others during the rotation are kept as is
+func RestoreNodeState(client kubernetes.Interface, nodeName string) error {
+	corev1Node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	state, ok, err := nodeState(corev1Node)
+	if err != nil || !ok {
+		return err
+	}
+	logrus.Infof("Restoring %s node state, unschedulable %t", nodeName, state.Unschedulable)
+
+	err = updateNode(client, nodeName, func(corev1Node *corev1.Node) {
+		corev1Node.Spec.Unschedulable = state.Unschedulable
+		taints := []corev1.Taint{}
+		for _, taint := range corev1Node.Spec.Taints {
+			if taint.Key != CordonTaint {
+				taints = append(taints, taint)
+			}
+		}
+		for _, saved := range state.Taints {
+			if !hasTaint(taints, saved) {
+				taints = append(taints, saved)
+			}
+		}
+		corev1Node.Spec.Taints = taints
+		for k, v := range state.Annotations {
+			if _, ok := corev1Node.Annotations[k]; !ok {
+				metav1.SetMetaDataAnnotation(&corev1Node.ObjectMeta, k, v)
+			}
+		}
+		delete(corev1Node.Annotations, CordonReasonAnnotation)
+		delete(corev1Node.Annotations, NodeStateAnnotation)
+	})
+	if err != nil {
+		logrus.Errorf("Error restoring %s node state: %v", nodeName, err)
+	}
+	return err
+}
+
+// updateNode updates the node changed by `mutate`,
+// spec.taints is replaced as a whole so the node is updated
+// at its resource version and retried on conflict
+func updateNode(client kubernetes.Interface, nodeName string, mutate func(*corev1.Node)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		corev1Node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		mutate(corev1Node)
+		_, err = client.CoreV1().Nodes().Update(context.TODO(), corev1Node, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// hasTaint checks the taints hold a taint with the key and the effect of `taint`
+func hasTaint(taints []corev1.Taint, taint corev1.Taint) bool {
+	for i := range taints {
+		if taints[i].MatchTaint(&taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeState returns the node state recorded in the node annotation
+func nodeState(corev1Node *corev1.Node) (*NodeState, bool, error) {
+	data, ok := corev1Node.GetAnnotations()[NodeStateAnnotation]
+	if !ok {
+		return nil, false, nil
+	}
+
+	state := &NodeState{}
+	if err := json.Unmarshal([]byte(data), state); err != nil {
+		return nil, true, fmt.Errorf("invalid %s node annotation %s: %w", corev1Node.GetName(), NodeStateAnnotation, err)
+	}
+	return state, true, nil
+}
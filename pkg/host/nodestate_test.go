@@ -0,0 +1,142 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNodeState(t *testing.T) {
+	maintenance := corev1.Taint{Key: "example.com/maintenance", Effect: corev1.TaintEffectNoSchedule}
+	unschedulable := corev1.Taint{Key: "node.kubernetes.io/unschedulable", Effect: corev1.TaintEffectNoSchedule}
+	notReady := corev1.Taint{Key: "node.kubernetes.io/not-ready", Effect: corev1.TaintEffectNoExecute}
+	kuceroTaint := corev1.Taint{Key: CordonTaint, Effect: corev1.TaintEffectNoSchedule}
+
+	tests := []struct {
+		name          string
+		unschedulable bool
+		taints        []corev1.Taint
+		annotations   map[string]string
+		// the cordon reason of the administrator is removed during the rotation
+		reasonRemoved  bool
+		expectedTaints []corev1.Taint
+	}{
+		{
+			name:           "schedulable node",
+			expectedTaints: []corev1.Taint{unschedulable, notReady},
+		},
+		{
+			name:           "node cordoned by the administrator",
+			unschedulable:  true,
+			taints:         []corev1.Taint{unschedulable, maintenance},
+			annotations:    map[string]string{"example.com/cordon-reason": "disk replacement"},
+			expectedTaints: []corev1.Taint{unschedulable, maintenance, notReady},
+		},
+		{
+			name:           "node cordoned by the administrator whose reason is removed during the rotation",
+			unschedulable:  true,
+			taints:         []corev1.Taint{unschedulable, maintenance},
+			annotations:    map[string]string{"example.com/cordon-reason": "disk replacement"},
+			reasonRemoved:  true,
+			expectedTaints: []corev1.Taint{unschedulable, notReady, maintenance},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: tt.annotations},
+				Spec:       corev1.NodeSpec{Unschedulable: tt.unschedulable, Taints: tt.taints},
+			})
+			get := func() *corev1.Node {
+				n, err := client.CoreV1().Nodes().Get(context.TODO(), "node-1", metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return n
+			}
+
+			if _, err := SaveNodeState(client, get()); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			// the rotation cordons the node
+			n := get()
+			if !tt.unschedulable {
+				n.Spec.Unschedulable = true
+				n.Spec.Taints = []corev1.Taint{unschedulable}
+				if _, err := client.CoreV1().Nodes().Update(context.TODO(), n, metav1.UpdateOptions{}); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if err := SetCordonReason(client, "node-1"); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				n = get()
+				if n.Annotations[CordonReasonAnnotation] == "" || !hasTaint(n.Spec.Taints, kuceroTaint) {
+					t.Fatalf("expected the kucero cordon reason set, got annotations %v taints %v", n.Annotations, n.Spec.Taints)
+				}
+			}
+
+			// another controller changes the node during the rotation
+			n.Annotations["example.com/cni"] = "ready"
+			n.Spec.Taints = append(n.Spec.Taints, notReady)
+			if tt.reasonRemoved {
+				delete(n.Annotations, "example.com/cordon-reason")
+				n.Spec.Taints = []corev1.Taint{unschedulable, notReady}
+			}
+			if _, err := client.CoreV1().Nodes().Update(context.TODO(), n, metav1.UpdateOptions{}); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			// the state saved before the interrupted rotation is kept
+			state, err := SaveNodeState(client, get())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if state.Unschedulable != tt.unschedulable {
+				t.Errorf("expected saved unschedulable %t, got %t", tt.unschedulable, state.Unschedulable)
+			}
+
+			if err := RestoreNodeState(client, "node-1"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			n = get()
+			if n.Spec.Unschedulable != tt.unschedulable {
+				t.Errorf("expected unschedulable %t, got %t", tt.unschedulable, n.Spec.Unschedulable)
+			}
+			if !reflect.DeepEqual(n.Spec.Taints, tt.expectedTaints) {
+				t.Errorf("expected taints %v, got %v", tt.expectedTaints, n.Spec.Taints)
+			}
+			if n.Annotations["example.com/cordon-reason"] != tt.annotations["example.com/cordon-reason"] {
+				t.Errorf("expected cordon reason %q, got %q", tt.annotations["example.com/cordon-reason"], n.Annotations["example.com/cordon-reason"])
+			}
+			if n.Annotations["example.com/cni"] != "ready" {
+				t.Errorf("expected the annotation set during the rotation kept, got %v", n.Annotations)
+			}
+			if _, ok := n.Annotations[CordonReasonAnnotation]; ok || HasNodeState(n) {
+				t.Errorf("expected annotations %s and %s removed, got %v", NodeStateAnnotation, CordonReasonAnnotation, n.Annotations)
+			}
+		})
+	}
+}
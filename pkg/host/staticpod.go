@@ -0,0 +1,195 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// StaticPodManifestsDir is the kubelet static pod manifests folder
+	StaticPodManifestsDir = "/etc/kubernetes/manifests"
+	// staticPodManifestsParkingDir is the folder the static pod manifest
+	// is moved to while stopping the static pod
+	staticPodManifestsParkingDir = "/etc/kubernetes/kucero-manifests"
+
+	staticPodPollInterval = 5 * time.Second
+)
+
+// staticPodHealthEndpoints are the kubeadm static pod liveness probe endpoints
+// on the host network, used to wait for the static pods without API access
+var staticPodHealthEndpoints map[string]string = map[string]string{
+	"etcd":                    "http://127.0.0.1:2381/health",
+	"kube-apiserver":          "https://127.0.0.1:6443/livez",
+	"kube-controller-manager": "https://127.0.0.1:10257/healthz",
+	"kube-scheduler":          "https://127.0.0.1:10259/healthz",
+}
+
+// RestartStaticPod restarts the static pod `component`, e.g. kube-apiserver,
+// by moving its manifest out of the static pod manifests folder until
+// the kubelet stops it, then moving it back and waiting for the mirror pod
+// to be Ready with containers started after the restart
+// without API access (client is nil), it waits for the static pod health endpoint instead
+func RestartStaticPod(client kubernetes.Interface, nodeName, component string, timeout time.Duration) error {
+	logrus.Infof("Commanding restart static pod %s on %s node", component, nodeName)
+
+	manifest := filepath.Join(StaticPodManifestsDir, component+".yaml")
+	parked := filepath.Join(staticPodManifestsParkingDir, component+".yaml")
+	mirrorPodName := component + "-" + nodeName
+
+	// Relies on hostPID:true and privileged:true to enter host mount space
+	cmd := NewCommand("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/mkdir", "-p", staticPodManifestsParkingDir)
+	if err := cmd.Run(); err != nil {
+		logrus.Errorf("Error invoking %s: %v", cmd.Args, err)
+		return err
+	}
+
+	stoppedAt := time.Now()
+	cmd = NewCommand("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/mv", manifest, parked)
+	if err := cmd.Run(); err != nil {
+		logrus.Errorf("Error invoking %s: %v", cmd.Args, err)
+		return err
+	}
+
+	// waits for the kubelet to stop the static pod,
+	// the apiserver is unavailable while kube-apiserver or etcd is stopped
+	stopErr := wait.PollUntilContextTimeout(context.TODO(), staticPodPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		if client == nil {
+			return !staticPodHealthy(component), nil
+		}
+		pod, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get(ctx, mirrorPodName, metav1.GetOptions{})
+		if err != nil {
+			return true, nil
+		}
+		return !podRunning(pod), nil
+	})
+	if stopErr != nil {
+		logrus.Warnf("Error waiting for static pod %s to stop: %v", component, stopErr)
+	}
+
+	// always moves the manifest back even if the static pod did not stop in time
+	cmd = NewCommand("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/mv", parked, manifest)
+	if err := cmd.Run(); err != nil {
+		logrus.Errorf("Error invoking %s: %v", cmd.Args, err)
+		return err
+	}
+
+	if client == nil {
+		err := wait.PollUntilContextTimeout(context.TODO(), staticPodPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+			return staticPodHealthy(component), nil
+		})
+		if err != nil {
+			return fmt.Errorf("static pod %s is not healthy after %s: %w", component, timeout, err)
+		}
+		logrus.Infof("Static pod %s is healthy", component)
+		return stopErr
+	}
+
+	if err := WaitForStaticPodReady(client, nodeName, component, stoppedAt, timeout); err != nil {
+		return err
+	}
+	return stopErr
+}
+
+// staticPodHealthy checks the static pod health endpoint on the host network
+func staticPodHealthy(component string) bool {
+	endpoint, ok := staticPodHealthEndpoints[component]
+	if !ok {
+		return false
+	}
+
+	httpClient := &http.Client{
+		Timeout: staticPodPollInterval,
+		Transport: &http.Transport{
+			// only the health of the local static pod is checked
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // nolint:gosec
+		},
+	}
+	resp, err := httpClient.Get(endpoint)
+	if err != nil {
+		logrus.Debugf("Error checking static pod %s health: %v", component, err)
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// WaitForStaticPodReady waits for the mirror pod of the static pod `component`
+// to be Ready with all containers started after `since`
+func WaitForStaticPodReady(client kubernetes.Interface, nodeName, component string, since time.Time, timeout time.Duration) error {
+	mirrorPodName := component + "-" + nodeName
+	logrus.Infof("Waiting for static pod %s to be Ready", mirrorPodName)
+
+	err := wait.PollUntilContextTimeout(context.TODO(), staticPodPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		pod, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get(ctx, mirrorPodName, metav1.GetOptions{})
+		if err != nil {
+			logrus.Debugf("Error getting static pod %s: %v", mirrorPodName, err)
+			return false, nil
+		}
+		return podReady(pod) && podStartedAfter(pod, since), nil
+	})
+	if err != nil {
+		return fmt.Errorf("static pod %s is not Ready after %s: %w", mirrorPodName, timeout, err)
+	}
+
+	logrus.Infof("Static pod %s is Ready", mirrorPodName)
+	return nil
+}
+
+func podRunning(pod *corev1.Pod) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podStartedAfter checks all pod containers are running since `t`,
+// the container start time has seconds precision
+func podStartedAfter(pod *corev1.Pod, t time.Time) bool {
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running == nil || status.State.Running.StartedAt.Time.Before(t.Truncate(time.Second)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/sirupsen/logrus"
+)
+
+const verifyPollInterval = 5 * time.Second
+
+// WaitForServiceActive waits for `systemctl is-active <service>`
+// on the host system to succeed
+func WaitForServiceActive(nodeName, service string, timeout time.Duration) error {
+	logrus.Infof("Waiting for %s to be active on %s node", service, nodeName)
+
+	err := wait.PollUntilContextTimeout(context.TODO(), verifyPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		// Relies on hostPID:true and privileged:true to enter host mount space
+		cmd := NewCommand("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/systemctl", "is-active", "--quiet", service)
+		if err := cmd.Run(); err != nil {
+			logrus.Debugf("Error invoking %s: %v", cmd.Args, err)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s is not active on %s node after %s: %w", service, nodeName, timeout, err)
+	}
+	return nil
+}
+
+// WaitForNodeReady waits for the node Ready condition to be true
+func WaitForNodeReady(client kubernetes.Interface, nodeName string, timeout time.Duration) error {
+	logrus.Infof("Waiting for %s node to be Ready", nodeName)
+
+	err := wait.PollUntilContextTimeout(context.TODO(), verifyPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			logrus.Debugf("Error getting node %s: %v", nodeName, err)
+			return false, nil
+		}
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady {
+				return condition.Status == corev1.ConditionTrue, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("node %s is not Ready after %s: %w", nodeName, timeout, err)
+	}
+	return nil
+}
+
+// NodeInternalIP returns the node InternalIP address
+func NodeInternalIP(client kubernetes.Interface, nodeName string) (string, error) {
+	node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP {
+			return address.Address, nil
+		}
+	}
+	return "", fmt.Errorf("node %s has no InternalIP address", nodeName)
+}
@@ -0,0 +1,239 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jenting/kucero/pkg/pki/clock"
+)
+
+// Lock is a coordination.k8s.io Lease based lock
+// the holder identity is `<node name>/<rotation id>`
+type Lock struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	nodeName  string
+	duration  time.Duration
+	clock     clock.Clock
+
+	// labels and annotations are set on the Lease creation
+	labels      map[string]string
+	annotations map[string]string
+}
+
+// New returns the Lease lock `namespace/name` for the node,
+// the lock is considered stale if it is not renewed within `duration`
+func New(client kubernetes.Interface, namespace, name, nodeName string, duration time.Duration) *Lock {
+	return &Lock{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		nodeName:  nodeName,
+		duration:  duration,
+		clock:     clock.NewRealClock(),
+	}
+}
+
+// Name returns the Lease name
+func (l *Lock) Name() string {
+	return l.name
+}
+
+// Holder returns the holder identity of the node and the rotation
+func (l *Lock) Holder(rotationID string) string {
+	return l.nodeName + "/" + rotationID
+}
+
+// Holding checks if the lock is held by the node
+// returns the holder identity
+func (l *Lock) Holding() (bool, string, error) {
+	lease, err := l.get()
+	if apierrors.IsNotFound(err) {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	holder := holderIdentity(lease)
+	return l.heldByNode(holder), holder, nil
+}
+
+// Acquire acquires the lock for the rotation if the lock is free,
+// held by the node, or not renewed by the holder within the lease duration
+// returns the current holder identity if the lock cannot be acquired
+func (l *Lock) Acquire(rotationID string) (bool, string, error) {
+	now := metav1.NewMicroTime(l.clock.Now())
+	holder := l.Holder(rotationID)
+	durationSeconds := int32(l.duration.Seconds())
+
+	lease, err := l.get()
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        l.name,
+				Namespace:   l.namespace,
+				Labels:      l.labels,
+				Annotations: l.annotations,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &durationSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		_, err = l.client.CoordinationV1().Leases(l.namespace).Create(context.TODO(), lease, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return false, "", nil
+		}
+		if err != nil {
+			return false, "", err
+		}
+		return true, holder, nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	current := holderIdentity(lease)
+	if current != "" && !l.heldByNode(current) && !l.expired(lease) {
+		return false, current, nil
+	}
+
+	transitions := int32(0)
+	if lease.Spec.LeaseTransitions != nil {
+		transitions = *lease.Spec.LeaseTransitions
+	}
+	if current != holder {
+		transitions++
+	}
+	for k, v := range l.labels {
+		metav1.SetMetaDataLabel(&lease.ObjectMeta, k, v)
+	}
+	for k, v := range l.annotations {
+		metav1.SetMetaDataAnnotation(&lease.ObjectMeta, k, v)
+	}
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &durationSeconds
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	lease.Spec.LeaseTransitions = &transitions
+
+	// the update fails on conflict if another node acquires the lock meanwhile
+	_, err = l.client.CoordinationV1().Leases(l.namespace).Update(context.TODO(), lease, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return false, current, nil
+	}
+	if err != nil {
+		return false, current, err
+	}
+	return true, holder, nil
+}
+
+// Renew renews the lock held by the rotation
+func (l *Lock) Renew(rotationID string) error {
+	lease, err := l.get()
+	if err != nil {
+		return err
+	}
+
+	holder := l.Holder(rotationID)
+	if current := holderIdentity(lease); current != holder {
+		return fmt.Errorf("lease %s/%s is held by %q, not %q", l.namespace, l.name, current, holder)
+	}
+
+	now := metav1.NewMicroTime(l.clock.Now())
+	lease.Spec.RenewTime = &now
+	_, err = l.client.CoordinationV1().Leases(l.namespace).Update(context.TODO(), lease, metav1.UpdateOptions{})
+	return err
+}
+
+// KeepAlive renews the lock held by the rotation every `interval`
+// until the returned stop function is called
+func (l *Lock) KeepAlive(rotationID string, interval time.Duration, onError func(error)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := l.Renew(rotationID); err != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Release releases the lock if it is held by the node
+func (l *Lock) Release() error {
+	lease, err := l.get()
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !l.heldByNode(holderIdentity(lease)) {
+		return nil
+	}
+
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.AcquireTime = nil
+	lease.Spec.RenewTime = nil
+	_, err = l.client.CoordinationV1().Leases(l.namespace).Update(context.TODO(), lease, metav1.UpdateOptions{})
+	return err
+}
+
+func (l *Lock) get() (*coordinationv1.Lease, error) {
+	return l.client.CoordinationV1().Leases(l.namespace).Get(context.TODO(), l.name, metav1.GetOptions{})
+}
+
+func (l *Lock) heldByNode(holder string) bool {
+	return strings.HasPrefix(holder, l.nodeName+"/")
+}
+
+// expired checks if the holder does not renew the lease within the lease duration
+func (l *Lock) expired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return !l.clock.Now().Before(expiry)
+}
+
+func holderIdentity(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lock
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type stubClock struct {
+	now time.Time
+}
+
+func (s *stubClock) Now() time.Time {
+	return s.now
+}
+
+func newTestLock(client *fake.Clientset, nodeName string, c *stubClock) *Lock {
+	l := New(client, "kube-system", "kucero", nodeName, time.Minute)
+	l.clock = c
+	return l
+}
+
+func TestLock(t *testing.T) {
+	tests := []struct {
+		name       string
+		elapsed    time.Duration
+		release    bool
+		expected   bool
+		expectedBy string
+	}{
+		{
+			name:       "lock held by another node",
+			elapsed:    30 * time.Second,
+			expected:   false,
+			expectedBy: "node-1/a",
+		},
+		{
+			name:       "lock released by another node",
+			elapsed:    30 * time.Second,
+			release:    true,
+			expected:   true,
+			expectedBy: "node-2/b",
+		},
+		{
+			name:       "lock not renewed by another node",
+			elapsed:    time.Minute,
+			expected:   true,
+			expectedBy: "node-2/b",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			c := &stubClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+			node1 := newTestLock(client, "node-1", c)
+			node2 := newTestLock(client, "node-2", c)
+
+			acquired, holder, err := node1.Acquire("a")
+			if err != nil || !acquired || holder != "node-1/a" {
+				t.Fatalf("expected node-1 to acquire the lock, got %v %q %v", acquired, holder, err)
+			}
+			if tt.release {
+				if err := node1.Release(); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+
+			c.now = c.now.Add(tt.elapsed)
+			acquired, holder, err = node2.Acquire("b")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if acquired != tt.expected || holder != tt.expectedBy {
+				t.Errorf("expected %v %q, got %v %q", tt.expected, tt.expectedBy, acquired, holder)
+			}
+		})
+	}
+}
+
+func TestRenew(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c := &stubClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	node1 := newTestLock(client, "node-1", c)
+	node2 := newTestLock(client, "node-2", c)
+
+	if acquired, _, err := node1.Acquire("a"); err != nil || !acquired {
+		t.Fatalf("expected node-1 to acquire the lock, got %v %v", acquired, err)
+	}
+
+	// the renewed lock is not stale after the initial lease duration
+	c.now = c.now.Add(45 * time.Second)
+	if err := node1.Renew("a"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	c.now = c.now.Add(45 * time.Second)
+	if acquired, _, _ := node2.Acquire("b"); acquired {
+		t.Fatal("expected node-2 not to acquire the renewed lock")
+	}
+
+	holding, holder, err := node1.Holding()
+	if err != nil || !holding || holder != "node-1/a" {
+		t.Fatalf("expected node-1 holding the lock, got %v %q %v", holding, holder, err)
+	}
+	if err := node1.Renew("c"); err == nil {
+		t.Error("expected error renewing the lock held by another rotation")
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// PoolLabel labels the Leases with the lock pool name
+	PoolLabel = "caasp.suse.com/kucero-lock-pool"
+	// SlotsAnnotation records the number of Leases in the lock pool of the holder,
+	// the nodes do not exceed the smallest number of the current holders
+	SlotsAnnotation = "caasp.suse.com/kucero-lock-slots"
+)
+
+// Semaphore allows up to `slots` nodes to hold the lock at the same time,
+// each slot is the Lease lock `<pool>-<index>` and a node holds at most one slot
+type Semaphore struct {
+	client    kubernetes.Interface
+	namespace string
+	pool      string
+	locks     []*Lock
+
+	// held is the slot acquired by the node
+	held *Lock
+}
+
+// NewSemaphore returns the semaphore of the lock pool `namespace/pool` for the node
+func NewSemaphore(client kubernetes.Interface, namespace, pool, nodeName string, slots int, duration time.Duration) *Semaphore {
+	s := &Semaphore{client: client, namespace: namespace, pool: pool}
+	for i := 0; i < slots; i++ {
+		l := New(client, namespace, fmt.Sprintf("%s-%d", pool, i), nodeName, duration)
+		l.labels = map[string]string{PoolLabel: pool}
+		l.annotations = map[string]string{SlotsAnnotation: strconv.Itoa(slots)}
+		s.locks = append(s.locks, l)
+	}
+	return s
+}
+
+// Pool returns the lock pool name
+func (s *Semaphore) Pool() string {
+	return s.pool
+}
+
+// Slots returns the number of nodes allowed to hold the lock at the same time
+func (s *Semaphore) Slots() int {
+	return len(s.locks)
+}
+
+// Holding checks if any slot is held by the node
+// returns the holder identity
+func (s *Semaphore) Holding() (bool, string, error) {
+	var errs error
+	for _, l := range s.locks {
+		holding, holder, err := l.Holding()
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		if holding {
+			return true, holder, nil
+		}
+	}
+	return false, "", errs
+}
+
+// Acquire acquires a slot for the rotation, the slot held by the node is preferred,
+// no slot is acquired while the current holders reach the smallest number of slots
+// configured on the node or recorded by the holders
+// returns the holder identities of all slots if no slot can be acquired
+func (s *Semaphore) Acquire(rotationID string) (bool, string, error) {
+	var locks []*Lock
+	for _, l := range s.locks {
+		if holding, _, err := l.Holding(); err == nil && holding {
+			locks = []*Lock{l}
+			break
+		}
+	}
+	if locks == nil {
+		holders, limit, err := s.holders()
+		if err != nil {
+			return false, "", err
+		}
+		if len(holders) >= limit {
+			return false, fmt.Sprintf("%v", holders), nil
+		}
+		locks = s.locks
+	}
+
+	var errs error
+	holders := []string{}
+	for _, l := range locks {
+		acquired, holder, err := l.Acquire(rotationID)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		if acquired {
+			s.held = l
+			return true, holder, nil
+		}
+		holders = append(holders, holder)
+	}
+	if errs != nil {
+		return false, "", errs
+	}
+	return false, fmt.Sprintf("%v", holders), nil
+}
+
+// holders returns the holder identities of the slots held by the other nodes,
+// and the smallest number of slots configured on the node or recorded by the holders
+func (s *Semaphore) holders() ([]string, int, error) {
+	leases, err := s.client.CoordinationV1().Leases(s.namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: PoolLabel + "=" + s.pool})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	holders := []string{}
+	limit := len(s.locks)
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		holder := holderIdentity(lease)
+		if holder == "" || s.locks[0].heldByNode(holder) || s.locks[0].expired(lease) {
+			continue
+		}
+		holders = append(holders, holder)
+		if slots, err := strconv.Atoi(lease.GetAnnotations()[SlotsAnnotation]); err == nil && slots > 0 && slots < limit {
+			limit = slots
+		}
+	}
+	return holders, limit, nil
+}
+
+// KeepAlive renews the slot held by the rotation every `interval`
+// until the returned stop function is called
+func (s *Semaphore) KeepAlive(rotationID string, interval time.Duration, onError func(error)) (stop func()) {
+	if s.held == nil {
+		return func() {}
+	}
+	return s.held.KeepAlive(rotationID, interval, onError)
+}
+
+// Release releases all slots held by the node
+func (s *Semaphore) Release() error {
+	var errs error
+	for _, l := range s.locks {
+		if err := l.Release(); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	s.held = nil
+	return errs
+}
@@ -0,0 +1,91 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSemaphore(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	newSemaphore := func(nodeName string) *Semaphore {
+		return NewSemaphore(client, "kube-system", "kucero-worker", nodeName, 2, time.Minute)
+	}
+	node1, node2, node3 := newSemaphore("node-1"), newSemaphore("node-2"), newSemaphore("node-3")
+
+	for _, s := range []*Semaphore{node1, node2} {
+		if acquired, _, err := s.Acquire("a"); err != nil || !acquired {
+			t.Fatalf("expected to acquire a slot, got %v %v", acquired, err)
+		}
+	}
+	if acquired, holder, err := node3.Acquire("a"); err != nil || acquired {
+		t.Fatalf("expected all slots held, got %v %q %v", acquired, holder, err)
+	}
+
+	// the node acquires the slot it already holds
+	if acquired, holder, err := node1.Acquire("b"); err != nil || !acquired || holder != "node-1/b" {
+		t.Fatalf("expected node-1 to acquire its slot again, got %v %q %v", acquired, holder, err)
+	}
+
+	if err := node1.Release(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if acquired, holder, err := node3.Acquire("a"); err != nil || !acquired || holder != "node-3/a" {
+		t.Fatalf("expected node-3 to acquire the released slot, got %v %q %v", acquired, holder, err)
+	}
+
+	leases, err := client.CoordinationV1().Leases("kube-system").List(context.TODO(), metav1.ListOptions{LabelSelector: PoolLabel + "=kucero-worker"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(leases.Items) != 2 {
+		t.Errorf("expected 2 leases in the pool, got %d", len(leases.Items))
+	}
+}
+
+func TestSemaphoreSmallestSlots(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	newSemaphore := func(nodeName string, slots int) *Semaphore {
+		return NewSemaphore(client, "kube-system", "kucero-worker", nodeName, slots, time.Minute)
+	}
+	// node-3 runs with a smaller --max-concurrent-worker-rotations
+	node1, node2, node3 := newSemaphore("node-1", 3), newSemaphore("node-2", 3), newSemaphore("node-3", 1)
+
+	if acquired, _, err := node3.Acquire("a"); err != nil || !acquired {
+		t.Fatalf("expected node-3 to acquire a slot, got %v %v", acquired, err)
+	}
+	if acquired, holder, err := node1.Acquire("a"); err != nil || acquired {
+		t.Fatalf("expected node-1 to honor the single slot of node-3, got %v %q %v", acquired, holder, err)
+	}
+
+	if err := node3.Release(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, s := range []*Semaphore{node1, node2} {
+		if acquired, _, err := s.Acquire("b"); err != nil || !acquired {
+			t.Fatalf("expected to acquire a slot, got %v %v", acquired, err)
+		}
+	}
+	if acquired, holder, err := node3.Acquire("b"); err != nil || acquired {
+		t.Fatalf("expected node-3 not to exceed its single slot, got %v %q %v", acquired, holder, err)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+const namespace = "kucero"
+
+// Rotation phases observed by the phase duration histogram
+const (
+	PhaseCordon   = "cordon"
+	PhaseDrain    = "drain"
+	PhaseRenew    = "renew"
+	PhaseRestart  = "restart"
+	PhaseVerify   = "verify"
+	PhaseRollback = "rollback"
+)
+
+var (
+	certificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "The certificate notAfter in seconds since the Unix epoch.",
+	}, []string{"node", "cert", "path"})
+
+	kubeletConfigDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kubelet_config_drift",
+		Help:      "Whether the kubelet configuration file needs to be updated (1) or not (0).",
+	}, []string{"node", "file"})
+
+	certificateDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "certificate_drift",
+		Help:      "Whether the certificate identity drifted and has to be reissued (1).",
+	}, []string{"node", "cert", "reason"})
+
+	certificateRequestRejected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "certificate_request_rejected",
+		Help:      "Whether the external CA denied or failed to sign the certificate request (1).",
+	}, []string{"node", "cert", "reason"})
+
+	rotationAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rotation_attempts_total",
+		Help:      "The number of certificate rotation attempts.",
+	}, []string{"node"})
+
+	rotationSuccesses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rotation_successes_total",
+		Help:      "The number of succeeded certificate rotations.",
+	}, []string{"node"})
+
+	rotationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rotation_failures_total",
+		Help:      "The number of failed certificate rotations.",
+	}, []string{"node"})
+
+	phaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rotation_phase_duration_seconds",
+		Help:      "The duration of the certificate rotation phases.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"node", "phase"})
+
+	// driftFiles remembers the kubelet configuration files ever reported
+	// to reset the drift once the file is updated
+	driftFiles = map[string]struct{}{}
+	driftMutex sync.Mutex
+)
+
+func init() {
+	// registers to the controller-runtime registry to serve
+	// the kucero metrics along with the CSR controller metrics
+	ctrlmetrics.Registry.MustRegister(
+		certificateExpiry,
+		kubeletConfigDrift,
+		certificateDrift,
+		certificateRequestRejected,
+		rotationAttempts,
+		rotationSuccesses,
+		rotationFailures,
+		phaseDuration,
+	)
+}
+
+// Serve serves the metrics endpoint /metrics on the address
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{}))
+
+	logrus.Infof("Serving metrics on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logrus.Errorf("Error serving metrics: %v", err)
+	}
+}
+
+// SetCertificateExpiry reports the notAfter of the node certificates
+func SetCertificateExpiry(nodeName string, infos []cert.Info) {
+	certificateExpiry.Reset()
+	for _, info := range infos {
+		certificateExpiry.WithLabelValues(nodeName, info.Name, info.Path).Set(float64(info.NotAfter.Unix()))
+	}
+}
+
+// SetKubeletConfigDrift reports the kubelet configuration files to be updated
+func SetKubeletConfigDrift(nodeName string, files []string) {
+	driftMutex.Lock()
+	defer driftMutex.Unlock()
+
+	for file := range driftFiles {
+		kubeletConfigDrift.WithLabelValues(nodeName, file).Set(0)
+	}
+	for _, file := range files {
+		driftFiles[file] = struct{}{}
+		kubeletConfigDrift.WithLabelValues(nodeName, file).Set(1)
+	}
+}
+
+// SetCertificateDrift reports the certificates whose identity drifted
+func SetCertificateDrift(nodeName string, drifts []cert.Drift) {
+	certificateDrift.Reset()
+	for _, drift := range drifts {
+		certificateDrift.WithLabelValues(nodeName, drift.Name, drift.Reason).Set(1)
+	}
+}
+
+// SetCertificateRequestRejected reports the certificates whose request the external CA rejected
+func SetCertificateRequestRejected(nodeName string, rejections []cert.Rejection) {
+	certificateRequestRejected.Reset()
+	for _, rejection := range rejections {
+		certificateRequestRejected.WithLabelValues(nodeName, rejection.Name, rejection.Reason).Set(1)
+	}
+}
+
+// ObserveRotation counts the certificate rotation attempt and its result
+func ObserveRotation(nodeName string, err error) {
+	rotationAttempts.WithLabelValues(nodeName).Inc()
+	if err != nil {
+		rotationFailures.WithLabelValues(nodeName).Inc()
+		return
+	}
+	rotationSuccesses.WithLabelValues(nodeName).Inc()
+}
+
+// ObservePhase observes the duration of the rotation phase since `start`
+func ObservePhase(nodeName, phase string, start time.Time) {
+	phaseDuration.WithLabelValues(nodeName, phase).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,56 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authority
+
+import (
+	"crypto/x509"
+	"errors"
+	"time"
+)
+
+// RenewalSigningPolicy is the signing policy to renew an existing certificate.
+//
+//  * It forwards all SANs from the original signing request.
+//  * It sets the key usages and extended key usages of the existing certificate.
+//  * It sets NotAfter based on the TTL configured in the policy.
+//  * It zeros all extensions.
+//  * It sets BasicConstraints to true.
+//  * It sets IsCA to false.
+type RenewalSigningPolicy struct {
+	// Certificate is the existing certificate to be renewed
+	Certificate *x509.Certificate
+	// TTL is the certificate TTL. It's used to calculate the NotAfter value of
+	// the certificate.
+	TTL time.Duration
+}
+
+func (p RenewalSigningPolicy) apply(tmpl *x509.Certificate) error {
+	if p.Certificate == nil {
+		return errors.New("no certificate to be renewed")
+	}
+
+	tmpl.KeyUsage = p.Certificate.KeyUsage
+	tmpl.ExtKeyUsage = p.Certificate.ExtKeyUsage
+	tmpl.NotAfter = tmpl.NotBefore.Add(p.TTL)
+
+	tmpl.ExtraExtensions = nil
+	tmpl.Extensions = nil
+	tmpl.BasicConstraintsValid = true
+	tmpl.IsCA = false
+
+	return nil
+}
@@ -16,7 +16,16 @@ limitations under the License.
 
 package cert
 
+import (
+	"net"
+	"time"
+)
+
 type Certificate interface {
+	// Inspect reads the node certificates
+	// returns the validity and identity of each certificate
+	Inspect() ([]Info, error)
+
 	// CheckExpiration checks node certificate
 	// returns the certificates which are going to expires
 	CheckExpiration() ([]string, error)
@@ -24,4 +33,77 @@ type Certificate interface {
 	// Rotate rotates the node certificates
 	// which are going to expires
 	Rotate(expiryCertificates []string) error
+
+	// Verify checks the node is healthy
+	// with the rotated certificates within timeout
+	Verify(timeout time.Duration) error
+
+	// Rollback restores the certificates
+	// backed up by the last rotation
+	Rollback() error
+
+	// Restart restarts the components other than kubelet
+	// which are using the certificates
+	Restart(certificateNames []string) error
+
+	// Backups returns the backup files
+	// taken by the last rotation
+	Backups() []string
+}
+
+// Info describes a certificate issued on the node
+type Info struct {
+	// Name is the certificate name, e.g. apiserver or admin.conf
+	Name string
+	// Path is the certificate or kubeconfig file path on the host system
+	Path string
+
+	NotBefore   time.Time
+	NotAfter    time.Time
+	Issuer      string
+	DNSNames    []string
+	IPAddresses []net.IP
+	// IsCA is true for the CAs, which are tracked but not renewed
+	IsCA bool
+}
+
+// DriftChecker is implemented by the certificate backends
+// detecting the certificates whose identity differs from the desired one
+type DriftChecker interface {
+	// CheckDrift returns the certificates to be reissued
+	// whatever their expiry
+	CheckDrift() ([]Drift, error)
+}
+
+// Drift describes a certificate whose identity drifted
+type Drift struct {
+	// Name is the certificate name, e.g. apiserver
+	Name string `json:"name"`
+	// Reason is why the certificate has to be reissued
+	Reason string `json:"reason"`
+}
+
+// ExternalSigner is implemented by the certificate backends
+// whose certificates can be signed by an external CA
+type ExternalSigner interface {
+	// SignedCertificates splits the certificates to be renewed
+	// into the certificates signed by the external CA, ready to be rotated,
+	// and the certificates waiting to be signed,
+	// returns the waiting certificates whose request the external CA rejected too
+	SignedCertificates(certificates []string) ([]string, []string, []Rejection)
+
+	// RequestCertificates requests the external CA to sign
+	// the certificates unless they were already requested,
+	// the rejected requests are requested again
+	RequestCertificates(certificates []string) error
+}
+
+// Rejection describes a certificate request the external CA rejected
+type Rejection struct {
+	// Name is the certificate name, e.g. apiserver
+	Name string `json:"name"`
+	// Reason is why the request was rejected, e.g. Denied or Failed
+	Reason string `json:"reason"`
+	// Message is the rejection message of the external CA
+	Message string `json:"message,omitempty"`
 }
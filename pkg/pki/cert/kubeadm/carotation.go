@@ -0,0 +1,583 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	certutil "k8s.io/client-go/util/cert"
+
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/pki/cert"
+	"github.com/jenting/kucero/pkg/pki/cert/kubelet"
+	"github.com/jenting/kucero/pkg/pki/clock"
+)
+
+const (
+	// CAPhasePrepare generates the new CA
+	CAPhasePrepare = "prepare"
+	// CAPhaseTrust distributes the trust bundle of the old and the new CA
+	CAPhaseTrust = "trust"
+	// CAPhaseReissue signs with the new CA and reissues the certificates signed by the old CA
+	CAPhaseReissue = "reissue"
+	// CAPhaseFinalize drops the old CA from the trust bundle
+	CAPhaseFinalize = "finalize"
+	// CAPhaseDone is the completed CA rotation
+	CAPhaseDone = "done"
+
+	// caLifetime is the lifetime of the kubeadm CAs
+	caLifetime = 10 * 365 * 24 * time.Hour
+
+	// caSigner is the CA certificate and key pair without extension under certificatesDir
+	// kube-controller-manager signs the cluster CSRs with during the rotation of the cluster CA,
+	// it does not accept a CA bundle
+	caSigner = "ca-signer"
+)
+
+// caPhases are the CA rotation phases in order
+var caPhases []string = []string{CAPhasePrepare, CAPhaseTrust, CAPhaseReissue, CAPhaseFinalize, CAPhaseDone}
+
+// signerFlags maps the CA file extension to the kube-controller-manager flag signing the cluster CSRs
+var signerFlags map[string]string = map[string]string{
+	".crt": "--cluster-signing-cert-file",
+	".key": "--cluster-signing-key-file",
+}
+
+// ValidateCertificateAuthority validates the kubeadm CA name, e.g. etcd-ca
+func ValidateCertificateAuthority(name string) error {
+	names := []string{}
+	for _, ca := range certificateAuthorities {
+		if strings.ReplaceAll(ca, "/", "-") == name {
+			return nil
+		}
+		names = append(names, strings.ReplaceAll(ca, "/", "-"))
+	}
+	return fmt.Errorf("invalid CA %q, must be one of %v", name, names)
+}
+
+// ValidateCAPhase validates the CA rotation phase
+func ValidateCAPhase(phase string) error {
+	for _, p := range caPhases {
+		if p == phase {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid CA rotation phase %q, must be one of %v", phase, caPhases)
+}
+
+// NextCAPhase returns the CA rotation phase after `phase`
+func NextCAPhase(phase string) string {
+	for i, p := range caPhases[:len(caPhases)-1] {
+		if p == phase {
+			return caPhases[i+1]
+		}
+	}
+	return CAPhaseDone
+}
+
+// NewCertificateAuthority generates a self-signed CA replacing the CA `old`
+// with the same subject and key algorithm and the kubeadm CA lifetime
+// returns the PEM encoded CA certificate and private key
+func NewCertificateAuthority(old *x509.Certificate, now time.Time) ([]byte, []byte, error) {
+	key, err := generateKey(keyAlgorithmOf(old.PublicKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               old.Subject,
+		NotBefore:             now.UTC(),
+		NotAfter:              now.Add(caLifetime).UTC(),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := marshalPrivateKeyPEM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// keyAlgorithmOf returns the key algorithm of the public key,
+// defaults to the kubeadm RSA 2048 bits
+func keyAlgorithmOf(pub crypto.PublicKey) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if algorithm := fmt.Sprintf("rsa-%d", k.N.BitLen()); validateKeyAlgorithm(algorithm) == nil {
+			return algorithm
+		}
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P384() {
+			return KeyECDSAP384
+		}
+		return KeyECDSAP256
+	case ed25519.PublicKey:
+		return KeyEd25519
+	}
+	return KeyRSA2048
+}
+
+// CARotation runs the staged rotation phases of the kubeadm CAs
+// and of the service account signing key on the node,
+// the control plane nodes rotate all of them, the worker nodes only trust the cluster CA
+type CARotation struct {
+	nodeName     string
+	client       kubernetes.Interface
+	controlPlane bool
+	clock        clock.Clock
+
+	// staticPodRestartTimeout is the time to wait for
+	// a restarted static pod to be Ready
+	staticPodRestartTimeout time.Duration
+	// verifyTimeout is the time to wait for
+	// the worker node kubelet client certificate to be signed by the new CA
+	verifyTimeout time.Duration
+	// kubeadmConfigPath is the local kubeadm ClusterConfiguration file,
+	// the kubeadm-config ConfigMap is used if empty
+	kubeadmConfigPath string
+}
+
+// NewCARotation returns the CA rotation of the node
+func NewCARotation(nodeName string, client kubernetes.Interface, isControlPlane bool, staticPodRestartTimeout, verifyTimeout time.Duration, kubeadmConfigPath string) *CARotation {
+	return &CARotation{
+		nodeName:                nodeName,
+		client:                  client,
+		controlPlane:            isControlPlane,
+		clock:                   clock.NewRealClock(),
+		staticPodRestartTimeout: staticPodRestartTimeout,
+		verifyTimeout:           verifyTimeout,
+		kubeadmConfigPath:       kubeadmConfigPath,
+	}
+}
+
+// Participates checks the node takes part in the rotation of the CA or the key `name`
+func (r *CARotation) Participates(name string) bool {
+	return r.controlPlane || name == "ca"
+}
+
+// Prepare generates the new CA or service account signing key replacing the node one `name`
+// returns the PEM encoded CA certificate or public key, and the private key
+func (r *CARotation) Prepare(name string) ([]byte, []byte, error) {
+	logrus.Infof("Commanding prepare %s node CA %s rotation", r.nodeName, name)
+
+	inv := discoverInventory(r.client, r.kubeadmConfigPath)
+	if name == ServiceAccountKey {
+		return r.prepareServiceAccountKey(inv.certificatesDir)
+	}
+	old, err := cert.ParseCertificateFile(authorityPath(inv.certificatesDir, name) + ".crt")
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewCertificateAuthority(old, r.clock.Now())
+}
+
+// Run runs the CA rotation phase trust, reissue or finalize of the CA or the key `name`
+// with the new CA certificate or public key and private key, the private key is only used by the control plane nodes in the reissue phase
+// the phases can be run again if they fail
+func (r *CARotation) Run(phase, name string, certPEM, keyPEM []byte) error {
+	logrus.Infof("Commanding %s node CA %s rotation phase %s", r.nodeName, name, phase)
+
+	if name == ServiceAccountKey {
+		inv := discoverInventory(r.client, r.kubeadmConfigPath)
+		return r.rotateServiceAccountKey(phase, inv.certificatesDir, certPEM, keyPEM)
+	}
+
+	newCA, err := cert.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return err
+	}
+	inv := discoverInventory(r.client, r.kubeadmConfigPath)
+	caPath := authorityPath(inv.certificatesDir, name)
+
+	switch phase {
+	case CAPhaseTrust:
+		return r.trust(inv, name, caPath, newCA)
+	case CAPhaseReissue:
+		return r.reissue(inv, name, caPath, newCA, keyPEM)
+	case CAPhaseFinalize:
+		return r.finalize(inv, name, caPath, newCA)
+	default:
+		return fmt.Errorf("invalid CA rotation phase %q to run on the node", phase)
+	}
+}
+
+// trust bundles the old CA first and the new CA, the old CA keeps signing
+func (r *CARotation) trust(inv *inventory, name, caPath string, newCA *x509.Certificate) error {
+	old, err := oldCertificateAuthority(caPath, newCA)
+	if err != nil {
+		return err
+	}
+	if err := r.backup(name, caPath); err != nil {
+		return err
+	}
+
+	if r.controlPlane && name == "ca" {
+		keyPEM, err := os.ReadFile(caPath + ".key")
+		if err != nil {
+			return err
+		}
+		if err := useSigner(inv.certificatesDir, encodeCertificates(old), keyPEM); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(caPath+".crt", encodeCertificates(old, newCA)); err != nil {
+		return err
+	}
+	if name == "ca" {
+		if err := updateKubeconfigs(kubernetesDir, encodeCertificates(old, newCA), old, newCA); err != nil {
+			return err
+		}
+	}
+
+	return r.restart(name)
+}
+
+// reissue bundles the new CA first and the old CA, the new CA signs from now on,
+// then reissues the certificates signed by the old CA
+func (r *CARotation) reissue(inv *inventory, name, caPath string, newCA *x509.Certificate, keyPEM []byte) error {
+	old, err := oldCertificateAuthority(caPath, newCA)
+	if err != nil {
+		return err
+	}
+	if err := r.backup(name, caPath); err != nil {
+		return err
+	}
+
+	// collects the certificates to be reissued before the CA changes
+	reissue := []string{}
+	if r.controlPlane {
+		for certificateName, certificatePath := range inv.certificates {
+			if signedBy(certificatePath, old) {
+				reissue = append(reissue, certificateName)
+			}
+		}
+		sort.Strings(reissue)
+
+		if err := writeFileAtomic(caPath+".key", keyPEM); err != nil {
+			return err
+		}
+		if name == "ca" {
+			if err := useSigner(inv.certificatesDir, encodeCertificates(newCA), keyPEM); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writeFileAtomic(caPath+".crt", encodeCertificates(newCA, old)); err != nil {
+		return err
+	}
+	if name == "ca" {
+		if err := updateKubeconfigs(kubernetesDir, encodeCertificates(newCA, old), old, newCA); err != nil {
+			return err
+		}
+	}
+
+	var errs error
+	now := r.clock.Now()
+	for _, certificateName := range reissue {
+		logrus.Infof("Reissuing %s node certificate %s with the new CA %s", r.nodeName, certificateName, name)
+		if err := renewCertificate(inv.certificates[certificateName], caPath, KeyReuse, subjectAltNames{}, now); err != nil {
+			logrus.Errorf("Error reissuing certificate %s: %v", certificateName, err)
+			errs = errors.Join(errs, err)
+		}
+	}
+	if name == "ca" && r.controlPlane {
+		// the control plane signs the kubelet client certificate itself,
+		// the cluster CSR signer may still be the old CA on another control plane node
+		if signedBy(kubelet.ResolveClientCertificate(host.Root), old) {
+			if err := renewKubeletClient(host.Root, caPath, now); err != nil {
+				logrus.Errorf("Error reissuing kubelet client certificate: %v", err)
+				errs = errors.Join(errs, err)
+			}
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+
+	if name == "ca" && !r.controlPlane {
+		// the worker nodes reissue phase runs after every control plane node signs with the new CA,
+		// kubelet requests its client certificate with the one signed by the old CA, still trusted
+		if err := kubelet.RenewClientCertificate(r.nodeName, r.verifyTimeout); err != nil {
+			return err
+		}
+	}
+
+	return r.restart(name)
+}
+
+// finalize drops the old CA from the trust bundle
+func (r *CARotation) finalize(inv *inventory, name, caPath string, newCA *x509.Certificate) error {
+	if err := r.backup(name, caPath); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(caPath+".crt", encodeCertificates(newCA)); err != nil {
+		return err
+	}
+	if name == "ca" {
+		if err := updateKubeconfigs(kubernetesDir, encodeCertificates(newCA), newCA); err != nil {
+			return err
+		}
+	}
+	if r.controlPlane && name == "ca" {
+		if err := setControllerManagerSigner(filepath.Join(inv.certificatesDir, caSigner), filepath.Join(inv.certificatesDir, "ca")); err != nil {
+			return err
+		}
+		for ext := range signerFlags {
+			if err := os.Remove(filepath.Join(inv.certificatesDir, caSigner+ext)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return r.restart(name)
+}
+
+// backup backups the CA certificate and private key on the control plane nodes
+func (r *CARotation) backup(name, caPath string) error {
+	if !r.controlPlane {
+		return nil
+	}
+	_, err := backupCertificate(r.nodeName, name, caPath+".crt")
+	return err
+}
+
+// restart restarts kubelet if the cluster CA is rotated,
+// then the static pods trusting the CA one by one
+func (r *CARotation) restart(name string) error {
+	if name == "ca" {
+		if err := host.RestartKubelet(r.nodeName); err != nil {
+			return err
+		}
+	}
+	if !r.controlPlane {
+		return nil
+	}
+
+	restart := map[string]bool{}
+	for _, staticPod := range authorityStaticPods[name] {
+		restart[staticPod] = true
+	}
+	var errs error
+	for _, staticPod := range orderStaticPods(restart) {
+		if err := host.RestartStaticPod(r.client, r.nodeName, staticPod, r.staticPodRestartTimeout); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
+
+// authorityPath returns the path without extension of the kubeadm CA `name`
+func authorityPath(certificatesDir, name string) string {
+	for _, ca := range certificateAuthorities {
+		if strings.ReplaceAll(ca, "/", "-") == name {
+			return filepath.Join(certificatesDir, ca)
+		}
+	}
+	return filepath.Join(certificatesDir, name)
+}
+
+// oldCertificateAuthority returns the CA other than the new CA in the CA certificate file
+func oldCertificateAuthority(caPath string, newCA *x509.Certificate) (*x509.Certificate, error) {
+	certs, err := certutil.CertsFromFile(caPath + ".crt")
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range certs {
+		if !c.Equal(newCA) {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("no CA other than the new CA found in %s", caPath+".crt")
+}
+
+// signedBy checks the certificate or kubeconfig client certificate is signed by the CA
+func signedBy(certificatePath string, ca *x509.Certificate) bool {
+	var c *x509.Certificate
+	var err error
+	if filepath.Ext(certificatePath) == ".conf" {
+		c, err = cert.ParseKubeconfigFile(certificatePath)
+	} else {
+		c, err = cert.ParseCertificateFile(certificatePath)
+	}
+	if err != nil {
+		logrus.Warnf("Error reading certificate %s: %v", certificatePath, err)
+		return false
+	}
+	return c.CheckSignatureFrom(ca) == nil
+}
+
+// encodeCertificates returns the PEM encoded certificates bundle
+func encodeCertificates(certs ...*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, c := range certs {
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return buf.Bytes()
+}
+
+// useSigner writes the CA kube-controller-manager signs the cluster CSRs with
+// and points kube-controller-manager to it
+func useSigner(certificatesDir string, certPEM, keyPEM []byte) error {
+	signerPath := filepath.Join(certificatesDir, caSigner)
+	if err := writeFileAtomic(signerPath+".key", keyPEM); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(signerPath+".crt", certPEM); err != nil {
+		return err
+	}
+	return setControllerManagerSigner(filepath.Join(certificatesDir, "ca"), signerPath)
+}
+
+// setControllerManagerSigner replaces the CA `from` with the CA `to`
+// in the kube-controller-manager static pod manifest signing flags, the paths are without extension
+func setControllerManagerSigner(from, to string) error {
+	manifest := filepath.Join(host.StaticPodManifestsDir, "kube-controller-manager.yaml")
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return err
+	}
+
+	updated := data
+	for ext, flag := range signerFlags {
+		updated = bytes.ReplaceAll(updated, []byte(flag+"="+from+ext), []byte(flag+"="+to+ext))
+	}
+	if bytes.Equal(updated, data) {
+		if bytes.Contains(data, []byte(signerFlags[".crt"]+"="+to+".crt")) {
+			return nil
+		}
+		logrus.Warnf("The kube-controller-manager does not sign with %s, skipping updating its signing flags", from)
+		return nil
+	}
+	// the temporary file is hidden from kubelet
+	return writeFileAtomic(manifest, updated)
+}
+
+// updateKubeconfigs sets the CA data to the bundle in the kubeconfigs
+// whose clusters trust one of the CAs
+func updateKubeconfigs(kubeconfigsDir string, bundle []byte, cas ...*x509.Certificate) error {
+	kubeconfigs, _ := filepath.Glob(filepath.Join(kubeconfigsDir, "*.conf"))
+
+	var errs error
+	for _, path := range kubeconfigs {
+		config, err := clientcmd.LoadFromFile(path)
+		if err != nil {
+			logrus.Warnf("Error loading kubeconfig %s: %v", path, err)
+			continue
+		}
+
+		updated := false
+		for _, cluster := range config.Clusters {
+			if len(cluster.CertificateAuthorityData) == 0 || bytes.Equal(cluster.CertificateAuthorityData, bundle) || !trustsAny(cluster.CertificateAuthorityData, cas) {
+				continue
+			}
+			cluster.CertificateAuthorityData = bundle
+			updated = true
+		}
+		if !updated {
+			continue
+		}
+
+		logrus.Infof("Updating kubeconfig %s CA data", path)
+		data, err := clientcmd.Write(*config)
+		if err == nil {
+			err = writeFileAtomic(path, data)
+		}
+		if err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
+
+// trustsAny checks the PEM encoded CA data holds one of the CAs
+func trustsAny(caData []byte, cas []*x509.Certificate) bool {
+	certs, err := certutil.ParseCertsPEM(caData)
+	if err != nil {
+		return false
+	}
+	for _, c := range certs {
+		for _, ca := range cas {
+			if c.Equal(ca) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renewKubeletClient reissues the kubelet client certificate and key file under `root`
+// with the CA at `caPath`, keeping the private key
+func renewKubeletClient(root, caPath string, now time.Time) error {
+	ca, err := loadCertificateAuthority(caPath, now)
+	if err != nil {
+		return err
+	}
+	certificatePath := kubelet.ResolveClientCertificate(root)
+	data, err := os.ReadFile(certificatePath)
+	if err != nil {
+		return err
+	}
+	old, err := cert.ParseCertificatePEM(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate %q: %w", certificatePath, err)
+	}
+	key, err := parsePrivateKeyPEM(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key %q: %w", certificatePath, err)
+	}
+
+	certPEM, err := reissueCertificate(ca, old, key, subjectAltNames{})
+	if err != nil {
+		return fmt.Errorf("failed to renew certificate %q: %w", certificatePath, err)
+	}
+	keyPEM, err := marshalPrivateKeyPEM(key)
+	if err != nil {
+		return err
+	}
+	return kubelet.UpdateClientCertificate(root, append(certPEM, keyPEM...), now)
+}
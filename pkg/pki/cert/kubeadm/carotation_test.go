@@ -0,0 +1,225 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+func TestNextCAPhase(t *testing.T) {
+	tests := []struct {
+		phase    string
+		expected string
+	}{
+		{phase: CAPhasePrepare, expected: CAPhaseTrust},
+		{phase: CAPhaseTrust, expected: CAPhaseReissue},
+		{phase: CAPhaseReissue, expected: CAPhaseFinalize},
+		{phase: CAPhaseFinalize, expected: CAPhaseDone},
+		{phase: CAPhaseDone, expected: CAPhaseDone},
+		{phase: "unknown", expected: CAPhaseDone},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.phase, func(t *testing.T) {
+			if got := NextCAPhase(tt.phase); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewCertificateAuthority(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	old, _ := writeTestCertificate(t, filepath.Join(dir, "ca"), &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		NotBefore:             now.Add(-10 * 365 * 24 * time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}, nil, nil)
+
+	certPEM, keyPEM, err := NewCertificateAuthority(old, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cert.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := parsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.IsCA || c.Subject.String() != old.Subject.String() {
+		t.Errorf("expected CA with subject %s, got CA %t with subject %s", old.Subject, c.IsCA, c.Subject)
+	}
+	if !c.NotAfter.Equal(now.Add(caLifetime)) {
+		t.Errorf("expected notAfter %v, got %v", now.Add(caLifetime), c.NotAfter)
+	}
+	if err := c.CheckSignatureFrom(c); err != nil {
+		t.Errorf("expected self-signed CA, got %v", err)
+	}
+	if _, ok := key.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("expected the ECDSA key algorithm of the old CA, got %T", key)
+	}
+	if !key.Public().(*ecdsa.PublicKey).Equal(c.PublicKey) {
+		t.Error("expected the private key of the CA certificate")
+	}
+}
+
+func TestUpdateKubeconfigs(t *testing.T) {
+	dir := t.TempDir()
+	writeCA := func(name string) *x509.Certificate {
+		c, _ := writeTestCertificate(t, filepath.Join(dir, name), &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}, nil, nil)
+		return c
+	}
+	oldCA, newCA, otherCA := writeCA("old"), writeCA("new"), writeCA("other")
+
+	writeKubeconfig := func(name string, caData []byte) string {
+		path := filepath.Join(dir, name)
+		config := clientcmdapi.NewConfig()
+		config.Clusters["kubernetes"] = &clientcmdapi.Cluster{Server: "https://127.0.0.1:6443", CertificateAuthorityData: caData}
+		if err := clientcmd.WriteToFile(*config, path); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	admin := writeKubeconfig("admin.conf", encodeCertificates(oldCA))
+	external := writeKubeconfig("external.conf", encodeCertificates(otherCA))
+
+	bundle := encodeCertificates(oldCA, newCA)
+	if err := updateKubeconfigs(dir, bundle, oldCA, newCA); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		expected []byte
+	}{
+		{path: admin, expected: bundle},
+		{path: external, expected: encodeCertificates(otherCA)},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(filepath.Base(tt.path), func(t *testing.T) {
+			config, err := clientcmd.LoadFromFile(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.Clusters["kubernetes"].CertificateAuthorityData; !bytes.Equal(got, tt.expected) {
+				t.Errorf("expected CA data %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	// the old CA is the other CA of the bundle
+	if err := os.WriteFile(filepath.Join(dir, "bundle.crt"), encodeCertificates(newCA, oldCA), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := oldCertificateAuthority(filepath.Join(dir, "bundle"), newCA)
+	if err != nil || !got.Equal(oldCA) {
+		t.Errorf("expected the old CA, got %v: %v", got, err)
+	}
+}
+
+func TestRenewKubeletClient(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	caTmpl := func(serial int64) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: "kubernetes"},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.Add(24 * time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+	}
+	oldCA, oldKey := writeTestCertificate(t, filepath.Join(dir, "old-ca"), caTmpl(1), nil, nil)
+	newCA, _ := writeTestCertificate(t, filepath.Join(dir, "ca"), caTmpl(2), nil, nil)
+
+	// kubelet stores the client certificate behind the kubelet-client-current.pem symbolic link
+	root := t.TempDir()
+	pkiDir := filepath.Join(root, "var", "lib", "kubelet", "pki")
+	if err := os.MkdirAll(pkiDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	client := filepath.Join(dir, "kubelet-client")
+	writeTestCertificate(t, client, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "system:node:master", Organization: []string{"system:nodes"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, oldCA, oldKey)
+	var bundle []byte
+	for _, ext := range []string{".crt", ".key"} {
+		data, err := os.ReadFile(client + ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle = append(bundle, data...)
+	}
+	if err := os.WriteFile(filepath.Join(pkiDir, "kubelet-client-2020-01-01-00-00-00.pem"), bundle, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/var/lib/kubelet/pki/kubelet-client-2020-01-01-00-00-00.pem", filepath.Join(pkiDir, "kubelet-client-current.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renewKubeletClient(root, filepath.Join(dir, "ca"), now); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	target, err := os.Readlink(filepath.Join(pkiDir, "kubelet-client-current.pem"))
+	if err != nil {
+		t.Fatalf("expected kubelet-client-current.pem to stay a symbolic link, got %v", err)
+	}
+	if expected := "/var/lib/kubelet/pki/kubelet-client-2021-01-01-00-00-00.pem"; target != expected {
+		t.Errorf("expected the symbolic link to point to %s, got %s", expected, target)
+	}
+	if !signedBy(filepath.Join(root, target), newCA) {
+		t.Errorf("expected the kubelet client certificate signed by the new CA")
+	}
+}
@@ -0,0 +1,227 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+const (
+	// apiServerCertificate is the kube-apiserver serving certificate name
+	apiServerCertificate = "apiserver"
+
+	// defaultServiceSubnet is the kubeadm default ClusterConfiguration networking.serviceSubnet
+	defaultServiceSubnet = "10.96.0.0/12"
+	// defaultDNSDomain is the kubeadm default ClusterConfiguration networking.dnsDomain
+	defaultDNSDomain = "cluster.local"
+)
+
+// subjectAltNames are the DNS names and IP addresses of a certificate
+type subjectAltNames struct {
+	DNSNames    []string
+	IPAddresses []net.IP
+}
+
+func (s subjectAltNames) empty() bool {
+	return len(s.DNSNames) == 0 && len(s.IPAddresses) == 0
+}
+
+func (s subjectAltNames) String() string {
+	names := []string{}
+	for _, dnsName := range s.DNSNames {
+		names = append(names, "DNS:"+dnsName)
+	}
+	for _, ip := range s.IPAddresses {
+		names = append(names, "IP:"+ip.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// add adds the DNS name or IP address
+func (s *subjectAltNames) add(name string) {
+	if ip := net.ParseIP(name); ip != nil {
+		s.IPAddresses = append(s.IPAddresses, ip)
+	} else if name != "" {
+		s.DNSNames = append(s.DNSNames, name)
+	}
+}
+
+// CheckDrift compares the SANs of the kube-apiserver serving certificate
+// with the SANs kubeadm issues it with, taken from the ClusterConfiguration,
+// the node InternalIP addresses and the kubernetes service IP,
+// returns the drift if the certificate misses any of them
+func (k *Kubeadm) CheckDrift() ([]cert.Drift, error) {
+	k.drifts = map[string]subjectAltNames{}
+
+	certificatePath, ok := k.certificates()[apiServerCertificate]
+	if !ok {
+		return nil, nil
+	}
+	if k.inventory.config == nil || k.client == nil {
+		logrus.Warnf("The kubeadm ClusterConfiguration or the node addresses are not available, skipping %s node certificate %s SANs check", k.nodeName, apiServerCertificate)
+		return nil, nil
+	}
+
+	addresses, err := nodeInternalIPs(k.client, k.nodeName)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := desiredAPIServerSANs(k.inventory.config, k.nodeName, addresses)
+	if err != nil {
+		return nil, err
+	}
+	c, err := cert.ParseCertificateFile(certificatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := missingSANs(c, desired)
+	if missing.empty() {
+		return nil, nil
+	}
+	reason := fmt.Sprintf("SANs %s are missing", missing)
+	if k.renewal.Method == RenewalKubeadm {
+		// kubeadm certs renew keeps the SANs of the existing certificate
+		logrus.Warnf("The %s node certificate %s %s, the native renewal is required to reissue it", k.nodeName, apiServerCertificate, reason)
+		return nil, nil
+	}
+
+	logrus.Infof("The %s node certificate %s %s, reissuing it", k.nodeName, apiServerCertificate, reason)
+	k.drifts[apiServerCertificate] = missing
+	return []cert.Drift{{Name: apiServerCertificate, Reason: reason}}, nil
+}
+
+// desiredAPIServerSANs returns the SANs kubeadm issues the kube-apiserver serving certificate with
+func desiredAPIServerSANs(config *clusterConfiguration, nodeName string, addresses []net.IP) (subjectAltNames, error) {
+	dnsDomain := config.Networking.DNSDomain
+	if dnsDomain == "" {
+		dnsDomain = defaultDNSDomain
+	}
+	serviceSubnet := config.Networking.ServiceSubnet
+	if serviceSubnet == "" {
+		serviceSubnet = defaultServiceSubnet
+	}
+	serviceIP, err := kubernetesServiceIP(serviceSubnet)
+	if err != nil {
+		return subjectAltNames{}, err
+	}
+
+	sans := subjectAltNames{
+		DNSNames: []string{
+			nodeName,
+			"kubernetes",
+			"kubernetes.default",
+			"kubernetes.default.svc",
+			"kubernetes.default.svc." + dnsDomain,
+		},
+		IPAddresses: append([]net.IP{serviceIP}, addresses...),
+	}
+
+	if endpoint := config.ControlPlaneEndpoint; endpoint != "" {
+		if host, _, err := net.SplitHostPort(endpoint); err == nil {
+			endpoint = host
+		}
+		sans.add(endpoint)
+	}
+	for _, san := range config.APIServer.CertSANs {
+		sans.add(san)
+	}
+	return sans, nil
+}
+
+// kubernetesServiceIP returns the kubernetes service IP,
+// the first IP of the first service subnet
+func kubernetesServiceIP(serviceSubnet string) (net.IP, error) {
+	subnet := strings.TrimSpace(strings.Split(serviceSubnet, ",")[0])
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service subnet %q: %w", serviceSubnet, err)
+	}
+
+	ip := new(big.Int).SetBytes(ipNet.IP)
+	ip.Add(ip, big.NewInt(1))
+	serviceIP := make(net.IP, len(ipNet.IP))
+	ip.FillBytes(serviceIP)
+	return serviceIP, nil
+}
+
+// missingSANs returns the desired SANs the certificate misses,
+// the SANs not desired are kept since kubeadm may issue
+// the certificate with addresses kucero does not know, e.g. the advertise address
+func missingSANs(c *x509.Certificate, desired subjectAltNames) subjectAltNames {
+	missing := subjectAltNames{}
+	for _, dnsName := range desired.DNSNames {
+		if !hasDNSName(c.DNSNames, dnsName) && !hasDNSName(missing.DNSNames, dnsName) {
+			missing.DNSNames = append(missing.DNSNames, dnsName)
+		}
+	}
+	for _, ip := range desired.IPAddresses {
+		if !hasIPAddress(c.IPAddresses, ip) && !hasIPAddress(missing.IPAddresses, ip) {
+			missing.IPAddresses = append(missing.IPAddresses, ip)
+		}
+	}
+	return missing
+}
+
+func hasDNSName(dnsNames []string, dnsName string) bool {
+	for _, n := range dnsNames {
+		if strings.EqualFold(n, dnsName) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasIPAddress(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeInternalIPs returns the node InternalIP addresses,
+// two on dual-stack nodes
+func nodeInternalIPs(client kubernetes.Interface, nodeName string) ([]net.IP, error) {
+	node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ips := []net.IP{}
+	for _, address := range node.Status.Addresses {
+		if address.Type != corev1.NodeInternalIP {
+			continue
+		}
+		if ip := net.ParseIP(address.Address); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
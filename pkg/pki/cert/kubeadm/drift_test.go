@@ -0,0 +1,179 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+func TestKubernetesServiceIP(t *testing.T) {
+	tests := []struct {
+		serviceSubnet string
+		expected      string
+		expectErr     bool
+	}{
+		{serviceSubnet: "10.96.0.0/12", expected: "10.96.0.1"},
+		{serviceSubnet: "10.96.0.0/12,fd00:10:96::/112", expected: "10.96.0.1"},
+		{serviceSubnet: "fd00:10:96::/112", expected: "fd00:10:96::1"},
+		{serviceSubnet: "10.96.0.0", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.serviceSubnet, func(t *testing.T) {
+			ip, err := kubernetesServiceIP(tt.serviceSubnet)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", ip)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ip.Equal(net.ParseIP(tt.expected)) {
+				t.Errorf("expected %s, got %s", tt.expected, ip)
+			}
+		})
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	ca, caKey := writeTestCertificate(t, filepath.Join(dir, "ca"), &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}, nil, nil)
+	writeTestCertificate(t, filepath.Join(dir, "apiserver"), &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "kube-apiserver"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"master", "kubernetes", "kubernetes.default", "kubernetes.default.svc", "kubernetes.default.svc.cluster.local"},
+		IPAddresses:  []net.IP{net.ParseIP("10.96.0.1"), net.ParseIP("192.168.0.10")},
+	}, ca, caKey)
+
+	client := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "master"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "192.168.0.10"},
+			{Type: corev1.NodeHostName, Address: "master"},
+		}},
+	})
+
+	tests := []struct {
+		name     string
+		config   *clusterConfiguration
+		renewal  string
+		expected []string
+	}{
+		{
+			name:   "no drift",
+			config: &clusterConfiguration{},
+		},
+		{
+			name: "certSANs added with kubeadm renewal",
+			config: func() *clusterConfiguration {
+				config := &clusterConfiguration{}
+				config.APIServer.CertSANs = []string{"192.168.0.100"}
+				return config
+			}(),
+			renewal: RenewalKubeadm,
+		},
+		{
+			name: "certSANs added",
+			config: func() *clusterConfiguration {
+				config := &clusterConfiguration{ControlPlaneEndpoint: "lb.example.com:6443"}
+				config.APIServer.CertSANs = []string{"192.168.0.100", "192.168.0.10"}
+				return config
+			}(),
+			expected: []string{"lb.example.com", "192.168.0.100"},
+		},
+		{
+			name: "no ClusterConfiguration",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			k := &Kubeadm{
+				nodeName: "master",
+				client:   client,
+				renewal:  Renewal{Method: tt.renewal},
+				inventory: &inventory{
+					certificatesDir: dir,
+					certificates:    map[string]string{"apiserver": filepath.Join(dir, "apiserver.crt")},
+					config:          tt.config,
+				},
+			}
+			drifts, err := k.CheckDrift()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.expected) == 0 {
+				if len(drifts) != 0 {
+					t.Errorf("expected no drift, got %v", drifts)
+				}
+				return
+			}
+			if len(drifts) != 1 || drifts[0].Name != "apiserver" {
+				t.Fatalf("expected apiserver drift, got %v", drifts)
+			}
+
+			// the reissued certificate has the existing and the missing SANs
+			if err := renewCertificate(filepath.Join(dir, "apiserver.crt"), filepath.Join(dir, "ca"), KeyReuse, k.drifts["apiserver"], now); err != nil {
+				t.Fatal(err)
+			}
+			c, err := cert.ParseCertificateFile(filepath.Join(dir, "apiserver.crt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sans := subjectAltNames{DNSNames: c.DNSNames, IPAddresses: c.IPAddresses}
+			for _, san := range append(tt.expected, "kubernetes", "10.96.0.1") {
+				expected := subjectAltNames{}
+				expected.add(san)
+				if missing := missingSANs(c, expected); !missing.empty() {
+					t.Errorf("expected SAN %s in %s", san, sans)
+				}
+			}
+			if drifts, err := k.CheckDrift(); err != nil || len(drifts) != 0 {
+				t.Errorf("expected no drift once reissued, got %v: %v", drifts, err)
+			}
+		})
+	}
+}
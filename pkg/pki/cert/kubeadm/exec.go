@@ -17,9 +17,11 @@ limitations under the License.
 package kubeadm
 
 import (
-	"fmt"
+	"os/exec"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"k8s.io/apimachinery/pkg/util/version"
@@ -27,26 +29,54 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/jenting/kucero/pkg/host"
-	"github.com/jenting/kucero/pkg/pki/clock"
+	"github.com/jenting/kucero/pkg/pki/cert"
 )
 
-// kubeadmAlphaCertsCheckExpiration executes `kubeadm alpha certs check-expiration`
-// returns the certificates which are going to expires
-func kubeadmAlphaCertsCheckExpiration(expiryTimeToRotate time.Duration, clock clock.Clock) ([]string, error) {
-	expiryCertificates := []string{}
+var (
+	kubeadmVersionMutex  sync.Mutex
+	kubeadmVersionCached *version.Version
+)
+
+// kubeadmVersion executes `kubeadm version -oshort` until it succeeds once
+// returns the kubeadm version on the host system
+func kubeadmVersion() (*version.Version, error) {
+	kubeadmVersionMutex.Lock()
+	defer kubeadmVersionMutex.Unlock()
+	if kubeadmVersionCached != nil {
+		return kubeadmVersionCached, nil
+	}
 
 	// Relies on hostPID:true and privileged:true to enter host mount space
 	cmd := host.NewCommandWithStdout("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/kubeadm", "version", "-oshort")
 	out, err := cmd.Output()
 	if err != nil {
 		logrus.Errorf("Error invoking %s: %v", cmd.Args, err)
-		return expiryCertificates, err
+		return nil, err
+	}
+
+	ver, err := version.ParseSemantic(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, err
+	}
+	kubeadmVersionCached = ver
+	return ver, nil
+}
+
+// kubeadmAlphaCertsCheckExpiration executes `kubeadm alpha certs check-expiration`
+// returns the certificates expiration time reported by kubeadm,
+// the path is looked up in the discovered `certificates`
+func kubeadmAlphaCertsCheckExpiration(certificates map[string]string) ([]cert.Info, error) {
+	infos := []cert.Info{}
+
+	ver, err := kubeadmVersion()
+	if err != nil {
+		return infos, err
 	}
 
 	// kubeadm >= 1.20.0: kubeadm certs check-expiration
 	// otherwise: kubeadm alpha certs check-expiration
-	ver := strings.TrimSuffix(string(out), "\n")
-	if version.MustParseSemantic(ver).AtLeast(version.MustParseSemantic("v1.20.0")) {
+	var cmd *exec.Cmd
+	if ver.AtLeast(version.MustParseSemantic("v1.20.0")) {
 		cmd = host.NewCommandWithStdout("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/kubeadm", "certs", "check-expiration")
 	} else {
 		cmd = host.NewCommandWithStdout("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/kubeadm", "alpha", "certs", "check-expiration")
@@ -54,34 +84,33 @@ func kubeadmAlphaCertsCheckExpiration(expiryTimeToRotate time.Duration, clock cl
 	stdout, err := cmd.Output()
 	if err != nil {
 		logrus.Errorf("Error invoking %s: %v", cmd.Args, err)
-		return expiryCertificates, err
+		return infos, err
 	}
 
 	stdoutS := string(stdout)
 	kv := parsekubeadmAlphaCertsCheckExpiration(stdoutS)
-	for cert, t := range kv {
-		expiry := checkCertificateExpiry(cert, t, expiryTimeToRotate, clock)
-		if expiry {
-			expiryCertificates = append(expiryCertificates, cert)
+	for name, t := range kv {
+		path, ok := certificates[name]
+		if !ok {
+			logrus.Warnf("The certificate %s reported by kubeadm is not found on the node", name)
 		}
+		infos = append(infos, cert.Info{Name: name, Path: path, NotAfter: t})
 	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
 
-	return expiryCertificates, nil
+	return infos, nil
 }
 
 func kubeadmAlphaCertsRenew(certificateName, certificatePath string) error {
-	// Relies on hostPID:true and privileged:true to enter host mount space
-	cmd := host.NewCommandWithStdout("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/kubeadm", "version", "-oshort")
-	out, err := cmd.Output()
+	ver, err := kubeadmVersion()
 	if err != nil {
-		logrus.Errorf("Error invoking %s: %v", cmd.Args, err)
 		return err
 	}
 
 	// kubeadm >= 1.20.0: kubeadm certs renew <certificate-name>
 	// otherwise: kubeadm alpha certs renew <certificate-name>
-	ver := strings.TrimSuffix(string(out), "\n")
-	if version.MustParseSemantic(ver).AtLeast(version.MustParseSemantic("v1.20.0")) {
+	var cmd *exec.Cmd
+	if ver.AtLeast(version.MustParseSemantic("v1.20.0")) {
 		cmd = host.NewCommandWithStdout("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/kubeadm", "certs", "renew", certificateName)
 	} else {
 		cmd = host.NewCommandWithStdout("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/kubeadm", "alpha", "certs", "renew", certificateName)
@@ -107,7 +136,7 @@ func parsekubeadmAlphaCertsCheckExpiration(input string) map[string]time.Time {
 			cert := strings.TrimSpace(ss[1])
 			t, err := time.Parse("Jan 02, 2006 15:04 MST", ss[2])
 			if err != nil {
-				fmt.Printf("err: %v\n", err)
+				logrus.Errorf("Error parsing certificate %s expiry %q: %v", cert, ss[2], err)
 				continue
 			}
 
@@ -121,18 +150,3 @@ func parsekubeadmAlphaCertsCheckExpiration(input string) map[string]time.Time {
 
 	return certExpires
 }
-
-// checkCertificateExpiry checks if the time `t` is less than the time duration `expiryTimeToRotate`
-func checkCertificateExpiry(name string, t time.Time, expiryTimeToRotate time.Duration, clock clock.Clock) bool {
-	tn := clock.Now()
-	if t.Before(tn) {
-		logrus.Infof("The certificate %s is expiry already", name)
-		return true
-	} else if t.Sub(tn) <= expiryTimeToRotate {
-		logrus.Infof("The certificate %s notAfter is less than user specified expiry time %s", name, expiryTimeToRotate)
-		return true
-	}
-
-	logrus.Infof("The certificate %s is still valid for %s", name, t.Sub(tn))
-	return false
-}
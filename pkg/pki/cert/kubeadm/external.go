@@ -0,0 +1,400 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	capi "k8s.io/api/certificates/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/certificate/csr"
+
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+// SignedCertificates splits the certificates to be renewed into the certificates
+// signed by the external CA, ready to be rotated, and the certificates waiting to be signed,
+// all the certificates are ready unless renewed by the external CA,
+// returns the waiting certificates whose request was rejected since the last signed one
+func (k *Kubeadm) SignedCertificates(certificates []string) ([]string, []string, []cert.Rejection) {
+	if k.renewal.Method != RenewalExternal {
+		return certificates, nil, nil
+	}
+
+	ready, pending, rejected := []string{}, []string{}, []cert.Rejection{}
+	for _, certificateName := range certificates {
+		certPEM, _, err := k.signedCertificate(certificateName)
+		var rejectedErr *rejectedRequestError
+		if errors.As(err, &rejectedErr) {
+			k.reject(certificateName, rejectedErr)
+		} else if err != nil {
+			logrus.Warnf("Error reading %s node certificate %s signed by the external CA: %v", k.nodeName, certificateName, err)
+		}
+		if certPEM != nil {
+			ready = append(ready, certificateName)
+			continue
+		}
+		pending = append(pending, certificateName)
+		if rejection, ok := k.rejections[certificateName]; ok {
+			rejected = append(rejected, rejection)
+		}
+	}
+	return ready, pending, rejected
+}
+
+// RequestCertificates writes the CSR and the private key of each certificate
+// under CSRDir, like `kubeadm certs generate-csr` does, and creates
+// the CertificateSigningRequest for the external CA if SignerName is set,
+// the certificates already requested are skipped unless their request was denied or failed
+func (k *Kubeadm) RequestCertificates(certificates []string) error {
+	if k.renewal.Method != RenewalExternal {
+		return nil
+	}
+
+	var errs error
+	for _, certificateName := range certificates {
+		if _, err := os.Stat(k.requestPath(certificateName) + ".key"); err == nil {
+			_, _, err := k.signedCertificate(certificateName)
+			var rejectedErr *rejectedRequestError
+			if !errors.As(err, &rejectedErr) {
+				logrus.Infof("The %s node certificate %s is waiting to be signed by the external CA", k.nodeName, certificateName)
+				continue
+			}
+			logrus.Warnf("The %s node certificate %s request is rejected, requesting it again: %v", k.nodeName, certificateName, err)
+			k.reject(certificateName, rejectedErr)
+			k.removeRequest(certificateName)
+		}
+		if err := k.requestCertificate(certificateName); err != nil {
+			logrus.Errorf("Error requesting %s node certificate %s: %v", k.nodeName, certificateName, err)
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
+
+// reject remembers the rejected request of the certificate until it is signed
+func (k *Kubeadm) reject(certificateName string, err *rejectedRequestError) {
+	if k.rejections == nil {
+		k.rejections = map[string]cert.Rejection{}
+	}
+	k.rejections[certificateName] = cert.Rejection{
+		Name:    certificateName,
+		Reason:  string(err.condition.Type),
+		Message: err.condition.Message,
+	}
+}
+
+// rejectedRequestError is returned if the external CA denied
+// the CertificateSigningRequest or failed to sign it
+type rejectedRequestError struct {
+	name      string
+	condition capi.CertificateSigningRequestCondition
+}
+
+func (e *rejectedRequestError) Error() string {
+	return fmt.Sprintf("CertificateSigningRequest %s is %s: %s", e.name, e.condition.Type, e.condition.Message)
+}
+
+// requestCertificate generates the CSR of the certificate with the subject and the SANs
+// of the existing certificate and publishes it
+func (k *Kubeadm) requestCertificate(certificateName string) error {
+	certificatePath, ok := k.certificates()[certificateName]
+	if !ok {
+		return fmt.Errorf("certificate %s not found", certificateName)
+	}
+	old, key, err := loadCertificateAndKey(certificatePath)
+	if err != nil {
+		return err
+	}
+	key, _, err = renewPrivateKey(key, k.renewal.KeyPolicy.For(certificateName))
+	if err != nil {
+		return err
+	}
+	keyPEM, err := marshalPrivateKeyPEM(key)
+	if err != nil {
+		return err
+	}
+	csrDER, err := newCertificateRequest(old, key, k.drifts[certificateName])
+	if err != nil {
+		return err
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+
+	if err := os.MkdirAll(k.renewal.CSRDir, 0700); err != nil {
+		return err
+	}
+	path := k.requestPath(certificateName)
+	if err := writeFileAtomic(path+".csr", csrPEM); err != nil {
+		return err
+	}
+	// the private key is written last, it marks the certificate requested
+	if err := writeFileAtomic(path+".key", keyPEM); err != nil {
+		return err
+	}
+
+	if k.renewal.SignerName == "" {
+		logrus.Infof("The %s node certificate %s CSR is written to %s.csr, waiting for the signed certificate %s.crt", k.nodeName, certificateName, path, path)
+		return nil
+	}
+	if k.client == nil {
+		return errors.New("creating the CertificateSigningRequest requires API access")
+	}
+
+	// a CertificateSigningRequest left by a previous request is for another private key
+	name := k.requestName(certificateName)
+	err = k.client.CertificatesV1().CertificateSigningRequests().Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	_, err = k.client.CertificatesV1().CertificateSigningRequests().Create(context.TODO(), &capi.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: capi.CertificateSigningRequestSpec{
+			Request:           csrPEM,
+			SignerName:        k.renewal.SignerName,
+			Usages:            keyUsages(old),
+			ExpirationSeconds: csr.DurationToExpirationSeconds(old.NotAfter.Sub(old.NotBefore)),
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create CertificateSigningRequest %s: %w", name, err)
+	}
+	logrus.Infof("The %s node certificate %s CertificateSigningRequest %s is created for signer %s", k.nodeName, certificateName, name, k.renewal.SignerName)
+	return nil
+}
+
+// signedCertificate returns the PEM encoded certificate signed by the external CA
+// and the requested private key, the certificate is nil if not signed yet
+func (k *Kubeadm) signedCertificate(certificateName string) ([]byte, []byte, error) {
+	path := k.requestPath(certificateName)
+	keyPEM, err := os.ReadFile(path + ".key")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var certPEM []byte
+	if k.renewal.SignerName != "" {
+		if k.client == nil {
+			return nil, nil, errors.New("reading the CertificateSigningRequest requires API access")
+		}
+		request, err := k.client.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), k.requestName(certificateName), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, c := range request.Status.Conditions {
+			if c.Type == capi.CertificateDenied || c.Type == capi.CertificateFailed {
+				return nil, nil, &rejectedRequestError{name: request.Name, condition: c}
+			}
+		}
+		certPEM = request.Status.Certificate
+	} else {
+		certPEM, err = os.ReadFile(path + ".crt")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+	if len(certPEM) == 0 {
+		return nil, nil, nil
+	}
+
+	if err := k.validateSignedCertificate(certificateName, certPEM, keyPEM); err != nil {
+		return nil, nil, err
+	}
+	return certPEM, keyPEM, nil
+}
+
+// validateSignedCertificate checks the signed certificate is for the requested private key,
+// issued by the CA of the existing certificate and valid now
+func (k *Kubeadm) validateSignedCertificate(certificateName string, certPEM, keyPEM []byte) error {
+	c, err := cert.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return err
+	}
+	key, err := parsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return err
+	}
+	if !equalPublicKey(key.Public(), c.PublicKey) {
+		return fmt.Errorf("the signed certificate %s is not for the requested private key", certificateName)
+	}
+
+	certificatePath := k.certificates()[certificateName]
+	caPath, err := findCertificateAuthority(k.inventory.certificatesDir, certificatePath)
+	if err != nil {
+		return err
+	}
+	ca, err := cert.ParseCertificateFile(caPath + ".crt")
+	if err != nil {
+		return err
+	}
+	if err := c.CheckSignatureFrom(ca); err != nil {
+		return fmt.Errorf("the signed certificate %s is not issued by the CA %s: %w", certificateName, caPath, err)
+	}
+
+	now := k.clock.Now()
+	if now.Before(c.NotBefore) || !now.Before(c.NotAfter) {
+		return fmt.Errorf("the signed certificate %s is not valid at %v", certificateName, now)
+	}
+	return nil
+}
+
+// installSignedCertificate writes the certificate signed by the external CA
+// and its private key, then removes the request
+func (k *Kubeadm) installSignedCertificate(certificateName, certificatePath string) error {
+	certPEM, keyPEM, err := k.signedCertificate(certificateName)
+	if err != nil {
+		return err
+	}
+	if certPEM == nil {
+		return fmt.Errorf("the certificate %s is not signed by the external CA yet", certificateName)
+	}
+
+	if filepath.Ext(certificatePath) == ".conf" {
+		err = writeKubeconfigClientCertificate(certificatePath, certPEM, keyPEM)
+	} else {
+		// the backups restore the pair if the certificate cannot be written
+		err = writeFileAtomic(strings.TrimSuffix(certificatePath, ".crt")+".key", keyPEM)
+		if err == nil {
+			err = writeFileAtomic(certificatePath, certPEM)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	k.removeRequest(certificateName)
+	delete(k.rejections, certificateName)
+	return nil
+}
+
+// removeRequest removes the request files and the CertificateSigningRequest
+func (k *Kubeadm) removeRequest(certificateName string) {
+	path := k.requestPath(certificateName)
+	for _, ext := range []string{".key", ".csr", ".crt"} {
+		if err := os.Remove(path + ext); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("Error removing %s: %v", path+ext, err)
+		}
+	}
+	if k.renewal.SignerName == "" {
+		return
+	}
+	err := k.client.CertificatesV1().CertificateSigningRequests().Delete(context.TODO(), k.requestName(certificateName), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logrus.Warnf("Error deleting CertificateSigningRequest %s: %v", k.requestName(certificateName), err)
+	}
+}
+
+// requestPath returns the path without extension of the certificate request files under CSRDir
+func (k *Kubeadm) requestPath(certificateName string) string {
+	return filepath.Join(k.renewal.CSRDir, certificateName)
+}
+
+// requestName returns the CertificateSigningRequest name of the node certificate
+func (k *Kubeadm) requestName(certificateName string) string {
+	return fmt.Sprintf("kucero-%s-%s", k.nodeName, certificateName)
+}
+
+// loadCertificateAndKey reads the certificate and its private key,
+// or the client certificate and key embedded in the kubeconfig
+func loadCertificateAndKey(certificatePath string) (*x509.Certificate, crypto.Signer, error) {
+	if filepath.Ext(certificatePath) != ".conf" {
+		c, err := cert.ParseCertificateFile(certificatePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := parsePrivateKeyFile(strings.TrimSuffix(certificatePath, ".crt") + ".key")
+		if err != nil {
+			return nil, nil, err
+		}
+		return c, key, nil
+	}
+
+	config, err := clientcmd.LoadFromFile(certificatePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	authInfo, err := currentAuthInfo(config, certificatePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := cert.ParseCertificatePEM(authInfo.ClientCertificateData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse client-certificate-data in kubeconfig %q: %w", certificatePath, err)
+	}
+	key, err := parsePrivateKeyPEM(authInfo.ClientKeyData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse client-key-data in kubeconfig %q: %w", certificatePath, err)
+	}
+	return c, key, nil
+}
+
+// writeKubeconfigClientCertificate embeds the client certificate and key
+// in the kubeconfig current context user
+func writeKubeconfigClientCertificate(kubeconfigPath string, certPEM, keyPEM []byte) error {
+	config, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if err != nil {
+		return err
+	}
+	authInfo, err := currentAuthInfo(config, kubeconfigPath)
+	if err != nil {
+		return err
+	}
+	authInfo.ClientCertificateData = certPEM
+	authInfo.ClientKeyData = keyPEM
+
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(kubeconfigPath, data)
+}
+
+// keyUsages returns the CertificateSigningRequest usages of the existing certificate
+func keyUsages(c *x509.Certificate) []capi.KeyUsage {
+	usages := []capi.KeyUsage{}
+	if c.KeyUsage&x509.KeyUsageDigitalSignature != 0 {
+		usages = append(usages, capi.UsageDigitalSignature)
+	}
+	if c.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+		usages = append(usages, capi.UsageKeyEncipherment)
+	}
+	for _, usage := range c.ExtKeyUsage {
+		switch usage {
+		case x509.ExtKeyUsageServerAuth:
+			usages = append(usages, capi.UsageServerAuth)
+		case x509.ExtKeyUsageClientAuth:
+			usages = append(usages, capi.UsageClientAuth)
+		}
+	}
+	return usages
+}
@@ -0,0 +1,271 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	capi "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jenting/kucero/pkg/pki/authority"
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+func TestExternalRenewal(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		signerName string
+	}{
+		{name: "CertificateSigningRequest", signerName: "example.com/external-ca"},
+		{name: "offline signing"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ca, caKey := writeTestCertificate(t, filepath.Join(dir, "ca"), &x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				Subject:               pkix.Name{CommonName: "kubernetes"},
+				NotBefore:             now.Add(-time.Hour),
+				NotAfter:              now.Add(10 * 365 * 24 * time.Hour),
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+				KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			}, nil, nil)
+			// the external CA private key is not on the node
+			if err := os.Remove(filepath.Join(dir, "ca.key")); err != nil {
+				t.Fatal(err)
+			}
+			old, _ := writeTestCertificate(t, filepath.Join(dir, "apiserver"), &x509.Certificate{
+				SerialNumber: big.NewInt(2),
+				Subject:      pkix.Name{CommonName: "kube-apiserver"},
+				NotBefore:    now.Add(-time.Hour),
+				NotAfter:     now.Add(24 * time.Hour),
+				KeyUsage:     x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+				DNSNames:     []string{"kubernetes"},
+				IPAddresses:  []net.IP{net.ParseIP("10.96.0.1")},
+			}, ca, caKey)
+
+			client := fake.NewSimpleClientset()
+			k := &Kubeadm{
+				nodeName: "master",
+				client:   client,
+				clock:    &fixedClock{now: now},
+				renewal: Renewal{
+					Method:     RenewalExternal,
+					KeyPolicy:  KeyPolicy{Algorithm: KeyECDSAP256},
+					SignerName: tt.signerName,
+					CSRDir:     filepath.Join(dir, "csr"),
+				},
+				inventory: &inventory{
+					certificatesDir: dir,
+					certificates:    map[string]string{"apiserver": filepath.Join(dir, "apiserver.crt")},
+				},
+			}
+
+			if err := k.RequestCertificates([]string{"apiserver"}); err != nil {
+				t.Fatal(err)
+			}
+			ready, pending, _ := k.SignedCertificates([]string{"apiserver"})
+			if len(ready) != 0 || !reflect.DeepEqual(pending, []string{"apiserver"}) {
+				t.Fatalf("expected apiserver pending, got ready %v pending %v", ready, pending)
+			}
+
+			// the external CA signs the CSR
+			csrPEM, err := os.ReadFile(filepath.Join(dir, "csr", "apiserver.csr"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			block, _ := pem.Decode(csrPEM)
+			signer := &authority.CertificateAuthority{Certificate: ca, PrivateKey: caKey, Now: func() time.Time { return now }}
+			der, err := signer.Sign(block.Bytes, authority.RenewalSigningPolicy{Certificate: old, TTL: 24 * time.Hour})
+			if err != nil {
+				t.Fatal(err)
+			}
+			certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+			if tt.signerName != "" {
+				request, err := client.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), "kucero-master-apiserver", metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if request.Spec.SignerName != tt.signerName || !reflect.DeepEqual(request.Spec.Usages, []capi.KeyUsage{capi.UsageDigitalSignature, capi.UsageServerAuth}) {
+					t.Errorf("expected signer %s with the usages of the certificate, got %s %v", tt.signerName, request.Spec.SignerName, request.Spec.Usages)
+				}
+				request.Status.Certificate = certPEM
+				if _, err := client.CertificatesV1().CertificateSigningRequests().UpdateStatus(context.TODO(), request, metav1.UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := os.WriteFile(filepath.Join(dir, "csr", "apiserver.crt"), certPEM, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			ready, pending, _ = k.SignedCertificates([]string{"apiserver"})
+			if !reflect.DeepEqual(ready, []string{"apiserver"}) || len(pending) != 0 {
+				t.Fatalf("expected apiserver ready, got ready %v pending %v", ready, pending)
+			}
+			if err := k.rotateCertificate("apiserver", filepath.Join(dir, "apiserver.crt")); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := cert.ParseCertificateFile(filepath.Join(dir, "apiserver.crt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := parsePrivateKeyFile(filepath.Join(dir, "apiserver.key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalPublicKey(key.Public(), c.PublicKey) || !reflect.DeepEqual(c.DNSNames, old.DNSNames) {
+				t.Errorf("expected the signed certificate with the new private key and the SANs %v, got %v", old.DNSNames, c.DNSNames)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "csr", "apiserver.key")); !os.IsNotExist(err) {
+				t.Errorf("expected the request removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExternalRenewalRejected(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		condition capi.RequestConditionType
+	}{
+		{name: "denied", condition: capi.CertificateDenied},
+		{name: "failed", condition: capi.CertificateFailed},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ca, caKey := writeTestCertificate(t, filepath.Join(dir, "ca"), &x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				Subject:               pkix.Name{CommonName: "kubernetes"},
+				NotBefore:             now.Add(-time.Hour),
+				NotAfter:              now.Add(10 * 365 * 24 * time.Hour),
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+				KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			}, nil, nil)
+			writeTestCertificate(t, filepath.Join(dir, "apiserver"), &x509.Certificate{
+				SerialNumber: big.NewInt(2),
+				Subject:      pkix.Name{CommonName: "kube-apiserver"},
+				NotBefore:    now.Add(-time.Hour),
+				NotAfter:     now.Add(24 * time.Hour),
+				KeyUsage:     x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+				DNSNames:     []string{"kubernetes"},
+			}, ca, caKey)
+
+			client := fake.NewSimpleClientset()
+			k := &Kubeadm{
+				nodeName: "master",
+				client:   client,
+				clock:    &fixedClock{now: now},
+				renewal: Renewal{
+					Method:     RenewalExternal,
+					KeyPolicy:  KeyPolicy{Algorithm: KeyECDSAP256},
+					SignerName: "example.com/external-ca",
+					CSRDir:     filepath.Join(dir, "csr"),
+				},
+				inventory: &inventory{
+					certificatesDir: dir,
+					certificates:    map[string]string{"apiserver": filepath.Join(dir, "apiserver.crt")},
+				},
+			}
+
+			if err := k.RequestCertificates([]string{"apiserver"}); err != nil {
+				t.Fatal(err)
+			}
+			csrPEM, err := os.ReadFile(filepath.Join(dir, "csr", "apiserver.csr"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the external CA rejects the request
+			request, err := client.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), "kucero-master-apiserver", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Status.Conditions = append(request.Status.Conditions, capi.CertificateSigningRequestCondition{
+				Type:    tt.condition,
+				Status:  "True",
+				Message: "rejected by the external CA",
+			})
+			if _, err := client.CertificatesV1().CertificateSigningRequests().UpdateStatus(context.TODO(), request, metav1.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := []cert.Rejection{{Name: "apiserver", Reason: string(tt.condition), Message: "rejected by the external CA"}}
+			ready, pending, rejected := k.SignedCertificates([]string{"apiserver"})
+			if len(ready) != 0 || !reflect.DeepEqual(pending, []string{"apiserver"}) || !reflect.DeepEqual(rejected, expected) {
+				t.Fatalf("expected apiserver pending and rejected, got ready %v pending %v rejected %v", ready, pending, rejected)
+			}
+
+			// the rejected request is requested again with a new CSR
+			if err := k.RequestCertificates(pending); err != nil {
+				t.Fatal(err)
+			}
+			newCSRPEM, err := os.ReadFile(filepath.Join(dir, "csr", "apiserver.csr"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reflect.DeepEqual(csrPEM, newCSRPEM) {
+				t.Error("expected a new CSR")
+			}
+			request, err = client.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), "kucero-master-apiserver", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(request.Status.Conditions) != 0 || !reflect.DeepEqual(request.Spec.Request, newCSRPEM) {
+				t.Errorf("expected the CertificateSigningRequest created again, got conditions %v", request.Status.Conditions)
+			}
+
+			// the rejection is reported until the certificate is signed
+			_, pending, rejected = k.SignedCertificates([]string{"apiserver"})
+			if !reflect.DeepEqual(pending, []string{"apiserver"}) || !reflect.DeepEqual(rejected, expected) {
+				t.Errorf("expected apiserver pending and rejected, got pending %v rejected %v", pending, rejected)
+			}
+		})
+	}
+}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.now
+}
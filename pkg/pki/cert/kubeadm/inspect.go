@@ -0,0 +1,70 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+// inspectCertificates reads the certificate files and the kubeconfig
+// embedded client certificates with crypto/x509
+// returns the certificates information sorted by certificate name
+func inspectCertificates(certificates map[string]string) ([]cert.Info, error) {
+	infos := []cert.Info{}
+
+	names := make([]string, 0, len(certificates))
+	for name := range certificates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := certificates[name]
+
+		var c *x509.Certificate
+		var err error
+		if filepath.Ext(path) == ".conf" {
+			c, err = cert.ParseKubeconfigFile(path)
+		} else {
+			c, err = cert.ParseCertificateFile(path)
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			// e.g. the etcd certificates do not exist with an external etcd
+			logrus.Debugf("The certificate %s path %s does not exist", name, path)
+			continue
+		}
+		if err != nil {
+			return infos, fmt.Errorf("failed to inspect certificate %s: %w", name, err)
+		}
+
+		infos = append(infos, cert.NewInfo(name, path, c))
+	}
+	if len(infos) == 0 {
+		return infos, errors.New("no kubeadm certificates found")
+	}
+
+	return infos, nil
+}
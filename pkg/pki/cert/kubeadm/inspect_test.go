@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// newTestCertificate returns a PEM encoded certificate signed by a throwaway CA
+func newTestCertificate(t *testing.T, commonName string, notBefore, notAfter time.Time, dnsNames []string, ips []net.IP) []byte {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func Test_inspectCertificates(t *testing.T) {
+	dir := t.TempDir()
+	notBefore := time.Date(2020, time.May, 12, 02, 29, 00, 00, time.UTC)
+	notAfter := time.Date(2021, time.May, 12, 02, 29, 00, 00, time.UTC)
+
+	apiserverPath := filepath.Join(dir, "apiserver.crt")
+	apiserverPEM := newTestCertificate(t, "kube-apiserver", notBefore, notAfter, []string{"kubernetes"}, []net.IP{net.ParseIP("10.96.0.1")})
+	if err := os.WriteFile(apiserverPath, apiserverPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	adminPath := filepath.Join(dir, "admin.conf")
+	adminConfig := clientcmdapi.NewConfig()
+	adminConfig.AuthInfos["kubernetes-admin"] = &clientcmdapi.AuthInfo{
+		ClientCertificateData: newTestCertificate(t, "kubernetes-admin", notBefore, notAfter.Add(time.Hour), nil, nil),
+	}
+	adminConfig.Contexts["kubernetes-admin@kubernetes"] = &clientcmdapi.Context{AuthInfo: "kubernetes-admin"}
+	adminConfig.CurrentContext = "kubernetes-admin@kubernetes"
+	if err := clientcmd.WriteToFile(*adminConfig, adminPath); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := inspectCertificates(map[string]string{
+		"admin.conf": adminPath,
+		"apiserver":  apiserverPath,
+		"etcd-peer":  filepath.Join(dir, "etcd", "peer.crt"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error but error reported: %v", err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("got %d certificates, expected 2", len(infos))
+	}
+
+	if infos[0].Name != "admin.conf" || infos[0].Path != adminPath {
+		t.Errorf("got %s %s, expected admin.conf %s", infos[0].Name, infos[0].Path, adminPath)
+	}
+	if !infos[0].NotAfter.Equal(notAfter.Add(time.Hour)) {
+		t.Errorf("got notAfter %v, expected %v", infos[0].NotAfter, notAfter.Add(time.Hour))
+	}
+
+	if infos[1].Name != "apiserver" || infos[1].Path != apiserverPath {
+		t.Errorf("got %s %s, expected apiserver %s", infos[1].Name, infos[1].Path, apiserverPath)
+	}
+	if !infos[1].NotBefore.Equal(notBefore) || !infos[1].NotAfter.Equal(notAfter) {
+		t.Errorf("got validity %v-%v, expected %v-%v", infos[1].NotBefore, infos[1].NotAfter, notBefore, notAfter)
+	}
+	if infos[1].Issuer != "CN=kubernetes" {
+		t.Errorf("got issuer %s, expected CN=kubernetes", infos[1].Issuer)
+	}
+	if !reflect.DeepEqual(infos[1].DNSNames, []string{"kubernetes"}) {
+		t.Errorf("got DNS SANs %v, expected [kubernetes]", infos[1].DNSNames)
+	}
+	if len(infos[1].IPAddresses) != 1 || !infos[1].IPAddresses[0].Equal(net.ParseIP("10.96.0.1")) {
+		t.Errorf("got IP SANs %v, expected [10.96.0.1]", infos[1].IPAddresses)
+	}
+}
+
+func Test_inspectCertificatesNotFound(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := inspectCertificates(map[string]string{
+		"apiserver": filepath.Join(dir, "apiserver.crt"),
+	})
+	if err == nil {
+		t.Error("expected an error when no certificate exists")
+	}
+}
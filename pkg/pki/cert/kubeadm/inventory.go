@@ -0,0 +1,328 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+const (
+	// kubernetesDir is the kubeadm kubeconfigs folder
+	kubernetesDir = "/etc/kubernetes"
+	// defaultCertificatesDir is the kubeadm default ClusterConfiguration certificatesDir
+	defaultCertificatesDir = "/etc/kubernetes/pki"
+
+	kubeadmConfigMapNamespace = "kube-system"
+	kubeadmConfigMapName      = "kubeadm-config"
+	kubeadmConfigMapKey       = "ClusterConfiguration"
+)
+
+// certificateAuthorities are the kubeadm CAs under certificatesDir without extension,
+// they are not renewed by kucero
+var certificateAuthorities []string = []string{"ca", "front-proxy-ca", "etcd/ca"}
+
+// certificateFlags are the static pod flags referencing a certificate file
+var certificateFlags []string = []string{
+	"--cert-file",
+	"--etcd-certfile",
+	"--kubelet-client-certificate",
+	"--peer-cert-file",
+	"--proxy-client-cert-file",
+	"--tls-cert-file",
+}
+
+// kubeconfigFlags are the static pod flags referencing a kubeconfig file
+var kubeconfigFlags []string = []string{
+	"--authentication-kubeconfig",
+	"--authorization-kubeconfig",
+	"--kubeconfig",
+}
+
+// unmanagedKubeconfigs are the kubeconfigs whose client certificate
+// is rotated by kubelet itself
+var unmanagedKubeconfigs []string = []string{"kubelet.conf", "bootstrap-kubelet.conf"}
+
+// clusterConfiguration is the part of the kubeadm ClusterConfiguration
+// locating the certificates and defining the kube-apiserver serving certificate SANs
+type clusterConfiguration struct {
+	Kind                 string `json:"kind"`
+	CertificatesDir      string `json:"certificatesDir"`
+	ControlPlaneEndpoint string `json:"controlPlaneEndpoint"`
+	APIServer            struct {
+		CertSANs []string `json:"certSANs"`
+	} `json:"apiServer"`
+	Networking struct {
+		ServiceSubnet string `json:"serviceSubnet"`
+		DNSDomain     string `json:"dnsDomain"`
+	} `json:"networking"`
+}
+
+// inventory is the certificates and kubeconfigs discovered on the node
+type inventory struct {
+	// certificatesDir is the kubeadm certificates and CAs folder
+	certificatesDir string
+	// certificates maps the certificate name to the certificate or kubeconfig path
+	certificates map[string]string
+	// authorities maps the CA name to the CA certificate path, e.g. etcd-ca
+	authorities map[string]string
+	// config is the kubeadm ClusterConfiguration, nil if it cannot be loaded
+	config *clusterConfiguration
+}
+
+// discoverInventory locates the certificates folder with the kubeadm ClusterConfiguration,
+// then discovers the certificates in it, the certificates and kubeconfigs referenced
+// by the static pod manifests, and the kubeconfigs with an embedded client certificate
+func discoverInventory(client kubernetes.Interface, kubeadmConfigPath string) *inventory {
+	certificatesDir := defaultCertificatesDir
+	config, err := loadClusterConfiguration(client, kubeadmConfigPath)
+	if err != nil {
+		logrus.Warnf("Error loading kubeadm ClusterConfiguration, using certificates folder %s: %v", certificatesDir, err)
+	} else if config != nil && config.CertificatesDir != "" {
+		certificatesDir = config.CertificatesDir
+	}
+
+	return &inventory{
+		certificatesDir: certificatesDir,
+		certificates:    discoverCertificates(certificatesDir, host.StaticPodManifestsDir, kubernetesDir),
+		authorities:     discoverCertificateAuthorities(certificatesDir),
+		config:          config,
+	}
+}
+
+// discoverCertificateAuthorities returns the CA name and path
+// of the kubeadm CAs found under certificatesDir
+func discoverCertificateAuthorities(certificatesDir string) map[string]string {
+	authorities := map[string]string{}
+	for _, ca := range certificateAuthorities {
+		path := filepath.Join(certificatesDir, ca+".crt")
+		if _, err := os.Stat(path); err == nil {
+			authorities[certificateName(certificatesDir, path)] = path
+		}
+	}
+	return authorities
+}
+
+// loadClusterConfiguration reads the kubeadm ClusterConfiguration from the local file
+// `kubeadmConfigPath` if set, otherwise from the kubeadm-config ConfigMap,
+// returns nil if neither is available
+func loadClusterConfiguration(client kubernetes.Interface, kubeadmConfigPath string) (*clusterConfiguration, error) {
+	if kubeadmConfigPath != "" {
+		data, err := os.ReadFile(kubeadmConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		return parseClusterConfiguration(data)
+	}
+	if client == nil {
+		return nil, nil
+	}
+
+	cm, err := client.CoreV1().ConfigMaps(kubeadmConfigMapNamespace).Get(context.TODO(), kubeadmConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	data, ok := cm.Data[kubeadmConfigMapKey]
+	if !ok {
+		return nil, fmt.Errorf("no %s in ConfigMap %s/%s", kubeadmConfigMapKey, kubeadmConfigMapNamespace, kubeadmConfigMapName)
+	}
+	return parseClusterConfiguration([]byte(data))
+}
+
+// parseClusterConfiguration finds the ClusterConfiguration in the YAML documents,
+// e.g. the kubeadm init configuration file
+func parseClusterConfiguration(data []byte) (*clusterConfiguration, error) {
+	for _, doc := range bytes.Split(data, []byte("\n---")) {
+		config := &clusterConfiguration{}
+		if err := yaml.Unmarshal(doc, config); err != nil {
+			return nil, err
+		}
+		if config.Kind == kubeadmConfigMapKey {
+			return config, nil
+		}
+	}
+	return nil, errors.New("no ClusterConfiguration found")
+}
+
+// discoverCertificates returns the certificate name and path
+// of the certificates and kubeconfigs found on the node
+func discoverCertificates(certificatesDir, manifestsDir, kubeconfigsDir string) map[string]string {
+	certificates := map[string]string{}
+	add := func(path string) {
+		name := certificateName(certificatesDir, path)
+		if existing, ok := certificates[name]; ok && existing != path {
+			logrus.Warnf("The certificate %s is found at %s and %s, using %s", name, existing, path, existing)
+			return
+		}
+		certificates[name] = path
+	}
+
+	for _, pattern := range []string{"*.crt", "etcd/*.crt"} {
+		paths, _ := filepath.Glob(filepath.Join(certificatesDir, pattern))
+		for _, path := range paths {
+			if !isCertificateAuthority(certificatesDir, path) {
+				add(path)
+			}
+		}
+	}
+
+	manifests, _ := filepath.Glob(filepath.Join(manifestsDir, "*.yaml"))
+	for _, manifest := range manifests {
+		paths, err := staticPodCertificates(manifest)
+		if err != nil {
+			logrus.Warnf("Error reading static pod manifest %s: %v", manifest, err)
+			continue
+		}
+		for _, path := range paths {
+			if filepath.Ext(path) == ".conf" && !hasClientCertificate(path) {
+				continue
+			}
+			if filepath.Ext(path) != ".conf" && isCertificateAuthority(certificatesDir, path) {
+				continue
+			}
+			add(path)
+		}
+	}
+
+	kubeconfigs, _ := filepath.Glob(filepath.Join(kubeconfigsDir, "*.conf"))
+	for _, path := range kubeconfigs {
+		if contains(unmanagedKubeconfigs, filepath.Base(path)) || !hasClientCertificate(path) {
+			continue
+		}
+		add(path)
+	}
+
+	return certificates
+}
+
+// staticPodCertificates returns the certificates and kubeconfigs
+// referenced by the static pod manifest container flags
+func staticPodCertificates(manifest string) ([]string, error) {
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return nil, err
+	}
+	pod := &corev1.Pod{}
+	if err := yaml.Unmarshal(data, pod); err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for _, container := range pod.Spec.Containers {
+		for _, arg := range append(container.Command, container.Args...) {
+			kv := strings.SplitN(arg, "=", 2)
+			if len(kv) != 2 || kv[1] == "" {
+				continue
+			}
+			if contains(certificateFlags, kv[0]) || contains(kubeconfigFlags, kv[0]) {
+				paths = append(paths, kv[1])
+			}
+		}
+	}
+	return paths, nil
+}
+
+// certificateName returns the kubeadm certificate name of the path,
+// the path relative to certificatesDir joined by dashes, e.g. etcd-server,
+// or the file name of a kubeconfig, e.g. admin.conf
+func certificateName(certificatesDir, path string) string {
+	if filepath.Ext(path) == ".conf" {
+		return filepath.Base(path)
+	}
+	if rel, err := filepath.Rel(certificatesDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return strings.ReplaceAll(strings.TrimSuffix(rel, filepath.Ext(rel)), string(filepath.Separator), "-")
+	}
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// isCertificateAuthority checks the path is a kubeadm CA,
+// or the CA kube-controller-manager signs with during the CA rotation
+func isCertificateAuthority(certificatesDir, path string) bool {
+	for _, ca := range append(certificateAuthorities, caSigner) {
+		if path == filepath.Join(certificatesDir, ca+".crt") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasClientCertificate checks the kubeconfig current context user has an embedded client certificate
+func hasClientCertificate(kubeconfigPath string) bool {
+	config, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if err != nil {
+		logrus.Debugf("Error loading kubeconfig %s: %v", kubeconfigPath, err)
+		return false
+	}
+	authInfo, err := currentAuthInfo(config, kubeconfigPath)
+	if err != nil {
+		logrus.Debugf("Error loading kubeconfig %s: %v", kubeconfigPath, err)
+		return false
+	}
+	return len(authInfo.ClientCertificateData) > 0 && len(authInfo.ClientKeyData) > 0
+}
+
+// findCertificateAuthority returns the path without extension
+// of the kubeadm CA under certificatesDir which signed the certificate or kubeconfig
+func findCertificateAuthority(certificatesDir, certificatePath string) (string, error) {
+	var c *x509.Certificate
+	var err error
+	if filepath.Ext(certificatePath) == ".conf" {
+		c, err = cert.ParseKubeconfigFile(certificatePath)
+	} else {
+		c, err = cert.ParseCertificateFile(certificatePath)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, ca := range certificateAuthorities {
+		caPath := filepath.Join(certificatesDir, ca)
+		caCert, err := cert.ParseCertificateFile(caPath + ".crt")
+		if err != nil {
+			continue
+		}
+		if c.CheckSignatureFrom(caCert) == nil {
+			return caPath, nil
+		}
+	}
+	return "", fmt.Errorf("no kubeadm CA under %s signed the certificate %s issued by %q", certificatesDir, certificatePath, c.Issuer.String())
+}
+
+func contains(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
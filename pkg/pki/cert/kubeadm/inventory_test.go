@@ -0,0 +1,253 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/keyutil"
+)
+
+// writeTestPKI writes the kubeadm CAs and the certificates signed by them
+// under `certificatesDir`, and the kubeconfigs under `kubeconfigsDir`
+func writeTestPKI(t *testing.T, certificatesDir, kubeconfigsDir string, certificates, kubeconfigs []string) {
+	t.Helper()
+
+	notBefore := time.Now().Add(-time.Hour)
+	caCerts := map[string]*x509.Certificate{}
+	caSigners := map[string]crypto.Signer{}
+	for i, ca := range certificateAuthorities {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(certificatesDir, ca)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		caCerts[ca], caSigners[ca] = writeTestCertificate(t, filepath.Join(certificatesDir, ca), &x509.Certificate{
+			SerialNumber:          big.NewInt(int64(i + 1)),
+			Subject:               pkix.Name{CommonName: ca},
+			NotBefore:             notBefore,
+			NotAfter:              notBefore.Add(24 * time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		}, nil, nil)
+	}
+
+	tmpl := func(cn string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(100),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    notBefore,
+			NotAfter:     notBefore.Add(24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+	}
+
+	for _, name := range certificates {
+		ca := "ca"
+		if filepath.Dir(name) == "etcd" {
+			ca = "etcd/ca"
+		}
+		writeTestCertificate(t, filepath.Join(certificatesDir, name), tmpl(name), caCerts[ca], caSigners[ca])
+	}
+
+	for _, name := range kubeconfigs {
+		path := filepath.Join(kubeconfigsDir, name)
+		c, key := writeTestCertificate(t, path, tmpl(name), caCerts["ca"], caSigners["ca"])
+		keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kubeconfig := clientcmdapi.NewConfig()
+		kubeconfig.AuthInfos["user"] = &clientcmdapi.AuthInfo{ClientCertificateData: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}), ClientKeyData: keyPEM}
+		kubeconfig.Contexts["user@kubernetes"] = &clientcmdapi.Context{AuthInfo: "user"}
+		kubeconfig.CurrentContext = "user@kubernetes"
+		if err := clientcmd.WriteToFile(*kubeconfig, path); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(path + ".crt")
+		os.Remove(path + ".key")
+	}
+}
+
+func TestDiscoverCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certificatesDir := filepath.Join(dir, "custom-pki")
+	manifestsDir := filepath.Join(dir, "manifests")
+	customDir := filepath.Join(dir, "custom")
+	for _, d := range []string{manifestsDir, customDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeTestPKI(t, certificatesDir, dir,
+		[]string{"apiserver", "front-proxy-client", "etcd/server", "etcd/healthcheck-client"},
+		[]string{"admin.conf", "super-admin.conf", "scheduler.conf", "kubelet.conf"},
+	)
+	writeTestPKI(t, customDir, dir, []string{"serving"}, nil)
+	if err := os.WriteFile(filepath.Join(dir, "controller-manager.conf"), []byte("apiVersion: v1\nkind: Config\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := `apiVersion: v1
+kind: Pod
+metadata:
+  name: kube-apiserver
+spec:
+  containers:
+  - name: kube-apiserver
+    command:
+    - kube-apiserver
+    - --client-ca-file=` + filepath.Join(certificatesDir, "ca.crt") + `
+    - --tls-cert-file=` + filepath.Join(customDir, "serving.crt") + `
+    - --kubelet-client-certificate=` + filepath.Join(certificatesDir, "apiserver-kubelet-client.crt") + `
+    - --authentication-kubeconfig=` + filepath.Join(dir, "scheduler.conf") + `
+    - --kubeconfig=` + filepath.Join(dir, "controller-manager.conf") + `
+`
+	if err := os.WriteFile(filepath.Join(manifestsDir, "kube-apiserver.yaml"), []byte(manifest), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"apiserver":                filepath.Join(certificatesDir, "apiserver.crt"),
+		"apiserver-kubelet-client": filepath.Join(certificatesDir, "apiserver-kubelet-client.crt"),
+		"front-proxy-client":       filepath.Join(certificatesDir, "front-proxy-client.crt"),
+		"etcd-server":              filepath.Join(certificatesDir, "etcd/server.crt"),
+		"etcd-healthcheck-client":  filepath.Join(certificatesDir, "etcd/healthcheck-client.crt"),
+		"serving":                  filepath.Join(customDir, "serving.crt"),
+		"admin.conf":               filepath.Join(dir, "admin.conf"),
+		"super-admin.conf":         filepath.Join(dir, "super-admin.conf"),
+		"scheduler.conf":           filepath.Join(dir, "scheduler.conf"),
+	}
+	got := discoverCertificates(certificatesDir, manifestsDir, dir)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestLoadClusterConfiguration(t *testing.T) {
+	config := `apiVersion: kubeadm.k8s.io/v1beta3
+kind: ClusterConfiguration
+certificatesDir: /etc/kubernetes/custom-pki
+`
+	dir := t.TempDir()
+	initConfiguration := filepath.Join(dir, "kubeadm.yaml")
+	if err := os.WriteFile(initConfiguration, []byte("apiVersion: kubeadm.k8s.io/v1beta3\nkind: InitConfiguration\n---\n"+config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		configPath  string
+		objects     []corev1.ConfigMap
+		expected    *clusterConfiguration
+		expectedErr bool
+	}{
+		{
+			name:       "local file",
+			configPath: initConfiguration,
+			expected:   &clusterConfiguration{Kind: "ClusterConfiguration", CertificatesDir: "/etc/kubernetes/custom-pki"},
+		},
+		{
+			name: "configmap",
+			objects: []corev1.ConfigMap{{
+				ObjectMeta: metav1.ObjectMeta{Name: kubeadmConfigMapName, Namespace: kubeadmConfigMapNamespace},
+				Data:       map[string]string{kubeadmConfigMapKey: config},
+			}},
+			expected: &clusterConfiguration{Kind: "ClusterConfiguration", CertificatesDir: "/etc/kubernetes/custom-pki"},
+		},
+		{
+			name:        "no configmap",
+			expectedErr: true,
+		},
+		{
+			name:        "local file not found",
+			configPath:  filepath.Join(dir, "not-found.yaml"),
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			for i := range tt.objects {
+				if err := client.Tracker().Add(&tt.objects[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := loadClusterConfiguration(client, tt.configPath)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("expected error %t, got %v", tt.expectedErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFindCertificateAuthority(t *testing.T) {
+	dir := t.TempDir()
+	certificatesDir := filepath.Join(dir, "pki")
+	writeTestPKI(t, certificatesDir, dir, []string{"apiserver", "etcd/peer"}, []string{"admin.conf"})
+	writeTestCertificate(t, filepath.Join(dir, "self-signed"), &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "self-signed"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, nil, nil)
+
+	tests := []struct {
+		path        string
+		expected    string
+		expectedErr bool
+	}{
+		{path: filepath.Join(certificatesDir, "apiserver.crt"), expected: filepath.Join(certificatesDir, "ca")},
+		{path: filepath.Join(certificatesDir, "etcd/peer.crt"), expected: filepath.Join(certificatesDir, "etcd/ca")},
+		{path: filepath.Join(dir, "admin.conf"), expected: filepath.Join(certificatesDir, "ca")},
+		{path: filepath.Join(dir, "self-signed.crt"), expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(filepath.Base(tt.path), func(t *testing.T) {
+			got, err := findCertificateAuthority(certificatesDir, tt.path)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("expected error %t, got %v", tt.expectedErr, err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"k8s.io/client-go/util/keyutil"
+)
+
+// Private key algorithms of the renewed certificates
+const (
+	// KeyReuse keeps the existing private key
+	KeyReuse     = "reuse"
+	KeyRSA2048   = "rsa-2048"
+	KeyRSA3072   = "rsa-3072"
+	KeyRSA4096   = "rsa-4096"
+	KeyECDSAP256 = "ecdsa-p256"
+	KeyECDSAP384 = "ecdsa-p384"
+	KeyEd25519   = "ed25519"
+)
+
+var keyAlgorithms []string = []string{KeyReuse, KeyRSA2048, KeyRSA3072, KeyRSA4096, KeyECDSAP256, KeyECDSAP384, KeyEd25519}
+
+// KeyPolicy is the private key algorithm of the renewed certificates
+type KeyPolicy struct {
+	// Algorithm applies to all certificates
+	Algorithm string
+	// Algorithms overrides Algorithm per certificate name
+	Algorithms map[string]string
+}
+
+// Renewal configures the kubeadm certificate renewal
+type Renewal struct {
+	// Method is the renewal method, native, kubeadm or external
+	Method string
+	// KeyPolicy is the private key policy of the native and external renewals
+	KeyPolicy KeyPolicy
+
+	// SignerName is the CertificateSigningRequest signerName of the external renewal,
+	// the CSRs are only written to CSRDir for offline signing if empty
+	SignerName string
+	// CSRDir is the folder of the external renewal CSRs, new private keys
+	// and signed certificates on the host system
+	CSRDir string
+}
+
+// NewRenewal validates and returns the kubeadm certificate renewal,
+// the kubeadm renewal does not regenerate the private keys
+func NewRenewal(method, keyAlgorithm string, keyAlgorithmOverrides map[string]string) (Renewal, error) {
+	if err := ValidateRenewal(method); err != nil {
+		return Renewal{}, err
+	}
+
+	if err := validateKeyAlgorithm(keyAlgorithm); err != nil {
+		return Renewal{}, err
+	}
+	if method == RenewalKubeadm && keyAlgorithm != KeyReuse {
+		return Renewal{}, fmt.Errorf("key algorithm %s requires the %s or %s renewal", keyAlgorithm, RenewalNative, RenewalExternal)
+	}
+	for name, algorithm := range keyAlgorithmOverrides {
+		if err := validateKeyAlgorithm(algorithm); err != nil {
+			return Renewal{}, err
+		}
+		if method == RenewalKubeadm && algorithm != KeyReuse {
+			return Renewal{}, fmt.Errorf("key algorithm %s of %s requires the %s or %s renewal", algorithm, name, RenewalNative, RenewalExternal)
+		}
+	}
+
+	policy := KeyPolicy{Algorithm: keyAlgorithm, Algorithms: keyAlgorithmOverrides}
+	return Renewal{Method: method, KeyPolicy: policy}, nil
+}
+
+// For returns the private key algorithm of the certificate
+func (p KeyPolicy) For(certificateName string) string {
+	if algorithm, ok := p.Algorithms[certificateName]; ok {
+		return algorithm
+	}
+	if p.Algorithm == "" {
+		return KeyReuse
+	}
+	return p.Algorithm
+}
+
+func validateKeyAlgorithm(algorithm string) error {
+	for _, a := range keyAlgorithms {
+		if a == algorithm {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid key algorithm %q, must be one of %v", algorithm, keyAlgorithms)
+}
+
+// generateKey generates the private key of the algorithm
+func generateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case KeyRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("cannot generate key of algorithm %q", algorithm)
+	}
+}
+
+// marshalPrivateKeyPEM encodes RSA and ECDSA keys the way kubeadm does,
+// and Ed25519 keys in PKCS#8
+func marshalPrivateKeyPEM(key crypto.Signer) ([]byte, error) {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: keyutil.PrivateKeyBlockType, Bytes: der}), nil
+	}
+	return keyutil.MarshalPrivateKeyToPEM(key)
+}
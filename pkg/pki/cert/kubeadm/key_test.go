@@ -0,0 +1,205 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/keyutil"
+
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+func TestNewRenewal(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		keyAlgorithm  string
+		keyAlgorithms map[string]string
+		expectedErr   bool
+	}{
+		{
+			name:         "native reuse",
+			method:       RenewalNative,
+			keyAlgorithm: KeyReuse,
+		},
+		{
+			name:          "native regenerate with overrides",
+			method:        RenewalNative,
+			keyAlgorithm:  KeyRSA2048,
+			keyAlgorithms: map[string]string{"apiserver": KeyECDSAP256, "admin.conf": KeyEd25519},
+		},
+		{
+			name:         "kubeadm reuse",
+			method:       RenewalKubeadm,
+			keyAlgorithm: KeyReuse,
+		},
+		{
+			name:         "kubeadm regenerate",
+			method:       RenewalKubeadm,
+			keyAlgorithm: KeyECDSAP256,
+			expectedErr:  true,
+		},
+		{
+			name:          "kubeadm regenerate override",
+			method:        RenewalKubeadm,
+			keyAlgorithm:  KeyReuse,
+			keyAlgorithms: map[string]string{"apiserver": KeyECDSAP256},
+			expectedErr:   true,
+		},
+		{
+			name:         "invalid key algorithm",
+			method:       RenewalNative,
+			keyAlgorithm: "dsa-1024",
+			expectedErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRenewal(tt.method, tt.keyAlgorithm, tt.keyAlgorithms)
+			if (err != nil) != tt.expectedErr {
+				t.Errorf("expected error %t, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestKeyPolicyFor(t *testing.T) {
+	policy := KeyPolicy{Algorithm: KeyRSA2048, Algorithms: map[string]string{"apiserver": KeyECDSAP256}}
+	if got := policy.For("apiserver"); got != KeyECDSAP256 {
+		t.Errorf("expected %s, got %s", KeyECDSAP256, got)
+	}
+	if got := policy.For("etcd-server"); got != KeyRSA2048 {
+		t.Errorf("expected %s, got %s", KeyRSA2048, got)
+	}
+	if got := (KeyPolicy{}).For("apiserver"); got != KeyReuse {
+		t.Errorf("expected %s, got %s", KeyReuse, got)
+	}
+}
+
+func TestRenewCertificateRegenerateKey(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	notBefore := now.Add(-360 * 24 * time.Hour)
+
+	tests := []struct {
+		keyAlgorithm string
+		expectedKey  crypto.PublicKey
+	}{
+		{keyAlgorithm: KeyRSA2048, expectedKey: &rsa.PublicKey{}},
+		{keyAlgorithm: KeyECDSAP256, expectedKey: &ecdsa.PublicKey{}},
+		{keyAlgorithm: KeyECDSAP384, expectedKey: &ecdsa.PublicKey{}},
+		{keyAlgorithm: KeyEd25519, expectedKey: ed25519.PublicKey{}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.keyAlgorithm, func(t *testing.T) {
+			dir := t.TempDir()
+			ca, caKey := writeTestCertificate(t, filepath.Join(dir, "ca"), &x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				Subject:               pkix.Name{CommonName: "kubernetes"},
+				NotBefore:             notBefore,
+				NotAfter:              notBefore.Add(10 * 365 * 24 * time.Hour),
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+				KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			}, nil, nil)
+			old, oldKey := writeTestCertificate(t, filepath.Join(dir, "apiserver-kubelet-client"), &x509.Certificate{
+				SerialNumber: big.NewInt(2),
+				Subject:      pkix.Name{CommonName: "kube-apiserver-kubelet-client", Organization: []string{"system:masters"}},
+				NotBefore:    notBefore,
+				NotAfter:     notBefore.Add(365 * 24 * time.Hour),
+				KeyUsage:     x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}, ca, caKey)
+
+			oldKeyPEM, err := keyutil.MarshalPrivateKeyToPEM(oldKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kubeconfig := clientcmdapi.NewConfig()
+			kubeconfig.AuthInfos["user"] = &clientcmdapi.AuthInfo{ClientCertificateData: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: old.Raw}), ClientKeyData: oldKeyPEM}
+			kubeconfig.Contexts["user@kubernetes"] = &clientcmdapi.Context{AuthInfo: "user"}
+			kubeconfig.CurrentContext = "user@kubernetes"
+			if err := clientcmd.WriteToFile(*kubeconfig, filepath.Join(dir, "admin.conf")); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, path := range []string{filepath.Join(dir, "apiserver-kubelet-client.crt"), filepath.Join(dir, "admin.conf")} {
+				if err := renewCertificate(path, filepath.Join(dir, "ca"), tt.keyAlgorithm, subjectAltNames{}, now); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+
+			renewed, err := cert.ParseCertificateFile(filepath.Join(dir, "apiserver-kubelet-client.crt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := parsePrivateKeyFile(filepath.Join(dir, "apiserver-kubelet-client.key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertRegeneratedKey(t, renewed, key, old, tt.expectedKey)
+
+			config, err := clientcmd.LoadFromFile(filepath.Join(dir, "admin.conf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			renewed, err = cert.ParseCertificatePEM(config.AuthInfos["user"].ClientCertificateData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err = parsePrivateKeyPEM(config.AuthInfos["user"].ClientKeyData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertRegeneratedKey(t, renewed, key, old, tt.expectedKey)
+		})
+	}
+}
+
+func assertRegeneratedKey(t *testing.T, renewed *x509.Certificate, key crypto.Signer, old *x509.Certificate, expectedKey crypto.PublicKey) {
+	t.Helper()
+
+	if reflect.TypeOf(renewed.PublicKey) != reflect.TypeOf(expectedKey) {
+		t.Errorf("expected public key %T, got %T", expectedKey, renewed.PublicKey)
+	}
+	if !reflect.DeepEqual(renewed.PublicKey, key.Public()) {
+		t.Error("expected the certificate to match the private key")
+	}
+	if reflect.DeepEqual(renewed.PublicKey, old.PublicKey) {
+		t.Error("expected the private key to be regenerated")
+	}
+	if renewed.Subject.String() != old.Subject.String() {
+		t.Errorf("expected subject %v, got %v", old.Subject, renewed.Subject)
+	}
+}
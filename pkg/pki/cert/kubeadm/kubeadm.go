@@ -17,44 +17,79 @@ limitations under the License.
 package kubeadm
 
 import (
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
 
 	"github.com/jenting/kucero/pkg/host"
 	"github.com/jenting/kucero/pkg/pki/cert"
 	"github.com/jenting/kucero/pkg/pki/clock"
 )
 
-var certificates map[string]string = map[string]string{
-	"admin.conf":               "/etc/kubernetes/admin.conf",
-	"controller-manager.conf":  "/etc/kubernetes/controller-manager.conf",
-	"scheduler.conf":           "/etc/kubernetes/scheduler.conf",
-	"apiserver":                "/etc/kubernetes/pki/apiserver.crt",
-	"apiserver-etcd-client":    "/etc/kubernetes/pki/apiserver-etcd-client.crt",
-	"apiserver-kubelet-client": "/etc/kubernetes/pki/apiserver-kubelet-client.crt",
-	"front-proxy-client":       "/etc/kubernetes/pki/front-proxy-client.crt",
-	"etcd-healthcheck-client":  "/etc/kubernetes/pki/etcd/healthcheck-client.crt",
-	"etcd-peer":                "/etc/kubernetes/pki/etcd/peer.crt",
-	"etcd-server":              "/etc/kubernetes/pki/etcd/server.crt",
-}
-
 type Kubeadm struct {
-	nodeName           string
-	expiryTimeToRotate time.Duration
-	clock              clock.Clock
+	nodeName     string
+	client       kubernetes.Interface
+	expiryPolicy cert.ExpiryPolicy
+	clock        clock.Clock
+
+	// staticPodRestartTimeout is the time to wait for
+	// a restarted static pod to be Ready
+	staticPodRestartTimeout time.Duration
+	// renewal is the certificate renewal method and key policy
+	renewal Renewal
+	// kubeadmConfigPath is the local kubeadm ClusterConfiguration file,
+	// the kubeadm-config ConfigMap is used if empty
+	kubeadmConfigPath string
+	// inventory is the certificates discovered by the last inspection
+	inventory *inventory
+	// drifts are the SANs the certificates miss found by the last drift check
+	drifts map[string]subjectAltNames
+	// rejections are the certificate requests the external CA rejected,
+	// kept until the certificate is signed
+	rejections map[string]cert.Rejection
+
+	// backups are the backup files taken by the last rotation
+	backups []backup
+	// rotated are the certificates renewed by the last rotation
+	rotated []string
 }
 
 // New returns the kubeadm instance
-func New(nodeName string, expiryTimeToRotate time.Duration) cert.Certificate {
+func New(nodeName string, client kubernetes.Interface, expiryPolicy cert.ExpiryPolicy, staticPodRestartTimeout time.Duration, renewal Renewal, kubeadmConfigPath string) cert.Certificate {
 	return &Kubeadm{
-		nodeName:           nodeName,
-		expiryTimeToRotate: expiryTimeToRotate,
-		clock:              clock.NewRealClock(),
+		nodeName:                nodeName,
+		client:                  client,
+		expiryPolicy:            expiryPolicy,
+		clock:                   clock.NewRealClock(),
+		staticPodRestartTimeout: staticPodRestartTimeout,
+		renewal:                 renewal,
+		kubeadmConfigPath:       kubeadmConfigPath,
+	}
+}
+
+// Inspect discovers and reads the control plane node certificates and CAs on the host system
+// falls back to `kubeadm certs check-expiration` if the certificates cannot be read
+func (k *Kubeadm) Inspect() ([]cert.Info, error) {
+	k.inventory = discoverInventory(k.client, k.kubeadmConfigPath)
+
+	certificates := map[string]string{}
+	for name, path := range k.inventory.authorities {
+		certificates[name] = path
 	}
+	for name, path := range k.inventory.certificates {
+		certificates[name] = path
+	}
+	infos, err := inspectCertificates(certificates)
+	if err != nil {
+		logrus.Warnf("Error inspecting %s node certificates, falling back to kubeadm: %v", k.nodeName, err)
+		return kubeadmAlphaCertsCheckExpiration(k.inventory.certificates)
+	}
+	return infos, nil
 }
 
 // CheckExpiration checks control plane node certificate
@@ -62,54 +97,178 @@ func New(nodeName string, expiryTimeToRotate time.Duration) cert.Certificate {
 func (k *Kubeadm) CheckExpiration() ([]string, error) {
 	logrus.Infof("Commanding check %s node certificate expiration", k.nodeName)
 
-	return kubeadmAlphaCertsCheckExpiration(k.expiryTimeToRotate, k.clock)
+	expiryCertificates := []string{}
+	infos, err := k.Inspect()
+	if err != nil {
+		return expiryCertificates, err
+	}
+
+	now := k.clock.Now()
+	for _, info := range infos {
+		expiry := k.expiryPolicy.CheckExpiry(info, now)
+		if expiry && info.IsCA {
+			// the CAs are rotated by the staged CA rotation
+			logrus.Warnf("The %s node CA %s expires at %v, it has to be rotated with the CA rotation", k.nodeName, info.Name, info.NotAfter)
+			continue
+		}
+		if expiry {
+			expiryCertificates = append(expiryCertificates, info.Name)
+		}
+	}
+
+	return expiryCertificates, nil
 }
 
 // Rotate executes the steps to rotates the certificate
-// including backing up certificate, rotates certificate, and restart kubelet
+// including backing up certificate, rotates certificate, restart kubelet,
+// and restart the static pods using the rotated certificates
 func (k *Kubeadm) Rotate(expiryCertificates []string) error {
 	var errs error
+	k.backups = []backup{}
+	k.rotated = []string{}
+	certificates := k.certificates()
 	for _, certificateName := range expiryCertificates {
 		certificatePath, ok := certificates[certificateName]
 		if !ok {
+			logrus.Warnf("The expiring certificate %s is not found on %s node, it has to be renewed manually", certificateName, k.nodeName)
 			continue
 		}
 
-		if err := backupCertificate(k.nodeName, certificateName, certificatePath); err != nil {
-			errs = fmt.Errorf("%w; ", err)
+		backups, err := backupCertificate(k.nodeName, certificateName, certificatePath)
+		k.backups = append(k.backups, backups...)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to back up %s: %w", certificateName, err))
 			continue
 		}
 
-		if err := rotateCertificate(k.nodeName, certificateName, certificatePath); err != nil {
-			errs = fmt.Errorf("%w; ", err)
+		if err := k.rotateCertificate(certificateName, certificatePath); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to renew %s: %w", certificateName, err))
 			continue
 		}
+		k.rotated = append(k.rotated, certificateName)
 	}
 	if errs != nil {
 		return errs
 	}
 
-	if err := host.RestartKubelet(k.nodeName); err != nil {
-		errs = fmt.Errorf("%w; ", err)
+	return k.restart()
+}
+
+// Verify waits for the local apiserver and etcd
+// to serve the rotated certificates
+func (k *Kubeadm) Verify(timeout time.Duration) error {
+	logrus.Infof("Commanding verify %s node certificate rotation", k.nodeName)
+
+	// the static pods serve on the host network
+	address, err := host.NodeInternalIP(k.client, k.nodeName)
+	if err != nil {
+		return err
+	}
+	return verifyServingCertificates(address, k.certificates(), timeout)
+}
+
+// Rollback restores the certificates backed up by the last rotation,
+// then restarts kubelet and the static pods using the restored certificates
+func (k *Kubeadm) Rollback() error {
+	logrus.Infof("Commanding rollback %s node certificate rotation", k.nodeName)
+
+	var errs error
+	for _, b := range k.backups {
+		if err := restoreFile(k.nodeName, b); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+
+	return k.restart()
+}
+
+// Backups returns the backup files taken by the last rotation
+func (k *Kubeadm) Backups() []string {
+	paths := []string{}
+	for _, b := range k.backups {
+		paths = append(paths, b.backupPath)
+	}
+	return paths
+}
+
+// Restart restarts the static pods using the certificates one by one,
+// waits for each of them to be Ready so the control plane is never restarted all at once
+func (k *Kubeadm) Restart(certificateNames []string) error {
+	var errs error
+	for _, staticPod := range StaticPods(certificateNames) {
+		if err := host.RestartStaticPod(k.client, k.nodeName, staticPod, k.staticPodRestartTimeout); err != nil {
+			errs = errors.Join(errs, err)
+		}
 	}
 
 	return errs
 }
 
+// certificates returns the certificates discovered by the last inspection,
+// or discovers them if the node was not inspected
+func (k *Kubeadm) certificates() map[string]string {
+	if k.inventory == nil {
+		k.inventory = discoverInventory(k.client, k.kubeadmConfigPath)
+	}
+	return k.inventory.certificates
+}
+
+// restart restarts kubelet and the static pods using the rotated certificates
+func (k *Kubeadm) restart() error {
+	if err := host.RestartKubelet(k.nodeName); err != nil {
+		return err
+	}
+	return k.Restart(k.rotated)
+}
+
+// backup is a file copied before the rotation
+type backup struct {
+	path       string
+	backupPath string
+}
+
 // backupCertificate backups the certificate/kubeconfig
-// under folder /etc/kubernetes issued by kubeadm
-func backupCertificate(nodeName string, certificateName, certificatePath string) error {
+// under folder /etc/kubernetes issued by kubeadm,
+// and the private key of the certificate since kubeadm renews it too
+// returns the backup files
+func backupCertificate(nodeName string, certificateName, certificatePath string) ([]backup, error) {
 	logrus.Infof("Commanding backup %s node certificate %s path %s", nodeName, certificateName, certificatePath)
 
-	dir := filepath.Dir(certificatePath)
-	base := filepath.Base(certificatePath)
-	ext := filepath.Ext(certificatePath)
-	certificateBackupPath := filepath.Join(dir, strings.TrimSuffix(base, ext)+"-"+time.Now().Format("20060102030405")+ext+".bak")
+	paths := []string{certificatePath}
+	if filepath.Ext(certificatePath) == ".crt" {
+		paths = append(paths, strings.TrimSuffix(certificatePath, ".crt")+".key")
+	}
+
+	backups := []backup{}
+	timestamp := time.Now().Format("20060102030405")
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		base := filepath.Base(path)
+		ext := filepath.Ext(path)
+		backupPath := filepath.Join(dir, strings.TrimSuffix(base, ext)+"-"+timestamp+ext+".bak")
+
+		// Relies on hostPID:true and privileged:true to enter host mount space
+		cmd := host.NewCommand("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/cp", "-p", path, backupPath)
+		if err := cmd.Run(); err != nil {
+			logrus.Errorf("Error invoking %s: %v", cmd.Args, err)
+			return backups, err
+		}
+		backups = append(backups, backup{path: path, backupPath: backupPath})
+	}
+
+	return backups, nil
+}
+
+// restoreFile copies the backup file back
+func restoreFile(nodeName string, b backup) error {
+	logrus.Infof("Commanding restore %s node file %s from %s", nodeName, b.path, b.backupPath)
 
 	// Relies on hostPID:true and privileged:true to enter host mount space
-	var err error
-	cmd := host.NewCommand("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/cp", certificatePath, certificateBackupPath)
-	err = cmd.Run()
+	cmd := host.NewCommand("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/cp", "-p", b.backupPath, b.path)
+	err := cmd.Run()
 	if err != nil {
 		logrus.Errorf("Error invoking %s: %v", cmd.Args, err)
 	}
@@ -117,14 +276,32 @@ func backupCertificate(nodeName string, certificateName, certificatePath string)
 	return err
 }
 
-// rotateCertificate calls `kubeadm alpha certs renew <cert-name>`
-// on the host system to rotates kubeadm issued certificates
-func rotateCertificate(nodeName string, certificateName, certificatePath string) error {
-	logrus.Infof("Commanding rotate %s node certificate %s path %s", nodeName, certificateName, certificatePath)
+// rotateCertificate renews the kubeadm issued certificate
+// natively with the CA on the host system, by calling
+// `kubeadm alpha certs renew <cert-name>` on the host system,
+// or installs the certificate signed by the external CA
+func (k *Kubeadm) rotateCertificate(certificateName, certificatePath string) error {
+	logrus.Infof("Commanding rotate %s node certificate %s path %s", k.nodeName, certificateName, certificatePath)
 
-	err := kubeadmAlphaCertsRenew(certificateName, certificatePath)
+	var err error
+	switch k.renewal.Method {
+	case RenewalKubeadm:
+		err = kubeadmAlphaCertsRenew(certificateName, certificatePath)
+	case RenewalExternal:
+		err = k.installSignedCertificate(certificateName, certificatePath)
+	default:
+		keyAlgorithm := k.renewal.KeyPolicy.For(certificateName)
+		if keyAlgorithm != KeyReuse {
+			logrus.Infof("Regenerating %s node certificate %s private key with %s", k.nodeName, certificateName, keyAlgorithm)
+		}
+		var caPath string
+		caPath, err = findCertificateAuthority(k.inventory.certificatesDir, certificatePath)
+		if err == nil {
+			err = renewCertificate(certificatePath, caPath, keyAlgorithm, k.drifts[certificateName], k.clock.Now())
+		}
+	}
 	if err != nil {
-		logrus.Errorf("Error invoking command: %v", err)
+		logrus.Errorf("Error renewing certificate %s: %v", certificateName, err)
 	}
 
 	return err
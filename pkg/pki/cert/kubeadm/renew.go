@@ -0,0 +1,279 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/keyutil"
+
+	"github.com/jenting/kucero/pkg/pki/authority"
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+const (
+	// RenewalNative renews the certificates with the CA on the host system
+	RenewalNative = "native"
+	// RenewalKubeadm renews the certificates with `kubeadm certs renew`
+	RenewalKubeadm = "kubeadm"
+	// RenewalExternal requests an external CA to sign the certificates,
+	// the CA private keys are not on the host system
+	RenewalExternal = "external"
+)
+
+// ValidateRenewal validates the certificate renewal method
+func ValidateRenewal(renewal string) error {
+	switch renewal {
+	case RenewalNative, RenewalKubeadm, RenewalExternal:
+		return nil
+	default:
+		return fmt.Errorf("invalid certificate renewal method %q, must be %s, %s or %s", renewal, RenewalNative, RenewalKubeadm, RenewalExternal)
+	}
+}
+
+// renewCertificate reissues the certificate or the kubeconfig client certificate
+// at `certificatePath` with the CA at `caPath`, keeping the subject, the SANs, the usages
+// and the lifetime, the SANs `add` are added to the existing ones,
+// the private key is reused or regenerated with `keyAlgorithm`,
+// the files are written atomically
+func renewCertificate(certificatePath, caPath, keyAlgorithm string, add subjectAltNames, now time.Time) error {
+	ca, err := loadCertificateAuthority(caPath, now)
+	if err != nil {
+		return err
+	}
+
+	if filepath.Ext(certificatePath) == ".conf" {
+		return renewKubeconfig(certificatePath, ca, keyAlgorithm)
+	}
+
+	old, err := cert.ParseCertificateFile(certificatePath)
+	if err != nil {
+		return err
+	}
+	keyPath := strings.TrimSuffix(certificatePath, ".crt") + ".key"
+	key, err := parsePrivateKeyFile(keyPath)
+	if err != nil {
+		return err
+	}
+
+	key, keyPEM, err := renewPrivateKey(key, keyAlgorithm)
+	if err != nil {
+		return fmt.Errorf("failed to renew certificate %q: %w", certificatePath, err)
+	}
+	certPEM, err := reissueCertificate(ca, old, key, add)
+	if err != nil {
+		return fmt.Errorf("failed to renew certificate %q: %w", certificatePath, err)
+	}
+
+	// the backups restore the pair if the certificate cannot be written
+	if keyPEM != nil {
+		if err := writeFileAtomic(keyPath, keyPEM); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(certificatePath, certPEM)
+}
+
+// renewPrivateKey returns the existing private key if `keyAlgorithm` is reuse,
+// otherwise a new private key and its PEM encoding
+func renewPrivateKey(key crypto.Signer, keyAlgorithm string) (crypto.Signer, []byte, error) {
+	if keyAlgorithm == KeyReuse || keyAlgorithm == "" {
+		return key, nil, nil
+	}
+
+	newKey, err := generateKey(keyAlgorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := marshalPrivateKeyPEM(newKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newKey, keyPEM, nil
+}
+
+// renewKubeconfig reissues the client certificate embedded in the kubeconfig current context user
+func renewKubeconfig(kubeconfigPath string, ca *authority.CertificateAuthority, keyAlgorithm string) error {
+	config, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if err != nil {
+		return err
+	}
+	authInfo, err := currentAuthInfo(config, kubeconfigPath)
+	if err != nil {
+		return err
+	}
+	if len(authInfo.ClientCertificateData) == 0 || len(authInfo.ClientKeyData) == 0 {
+		return fmt.Errorf("no embedded client certificate and key found in kubeconfig %q", kubeconfigPath)
+	}
+
+	old, err := cert.ParseCertificatePEM(authInfo.ClientCertificateData)
+	if err != nil {
+		return fmt.Errorf("failed to parse client-certificate-data in kubeconfig %q: %w", kubeconfigPath, err)
+	}
+	key, err := parsePrivateKeyPEM(authInfo.ClientKeyData)
+	if err != nil {
+		return fmt.Errorf("failed to parse client-key-data in kubeconfig %q: %w", kubeconfigPath, err)
+	}
+
+	key, keyPEM, err := renewPrivateKey(key, keyAlgorithm)
+	if err != nil {
+		return fmt.Errorf("failed to renew kubeconfig %q: %w", kubeconfigPath, err)
+	}
+	certPEM, err := reissueCertificate(ca, old, key, subjectAltNames{})
+	if err != nil {
+		return fmt.Errorf("failed to renew kubeconfig %q: %w", kubeconfigPath, err)
+	}
+	authInfo.ClientCertificateData = certPEM
+	if keyPEM != nil {
+		authInfo.ClientKeyData = keyPEM
+	}
+
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(kubeconfigPath, data)
+}
+
+// reissueCertificate signs a new certificate for the private key
+// with the subject, the SANs, the usages and the lifetime of the existing certificate
+// and the SANs `add` appended
+// returns the PEM encoded certificate
+func reissueCertificate(ca *authority.CertificateAuthority, old *x509.Certificate, key crypto.Signer, add subjectAltNames) ([]byte, error) {
+	csrDER, err := newCertificateRequest(old, key, add)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := ca.Sign(csrDER, authority.RenewalSigningPolicy{
+		Certificate: old,
+		TTL:         old.NotAfter.Sub(old.NotBefore),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// newCertificateRequest returns the DER encoded CSR for the private key
+// with the subject and the SANs of the existing certificate and the SANs `add` appended
+func newCertificateRequest(old *x509.Certificate, key crypto.Signer, add subjectAltNames) ([]byte, error) {
+	csrTmpl := &x509.CertificateRequest{
+		Subject:        old.Subject,
+		DNSNames:       append(append([]string{}, old.DNSNames...), add.DNSNames...),
+		IPAddresses:    append(append([]net.IP{}, old.IPAddresses...), add.IPAddresses...),
+		EmailAddresses: old.EmailAddresses,
+		URIs:           old.URIs,
+	}
+	return x509.CreateCertificateRequest(rand.Reader, csrTmpl, key)
+}
+
+// loadCertificateAuthority reads the CA certificate `<caPath>.crt` and key `<caPath>.key`
+func loadCertificateAuthority(caPath string, now time.Time) (*authority.CertificateAuthority, error) {
+	caCert, err := cert.ParseCertificateFile(caPath + ".crt")
+	if err != nil {
+		return nil, err
+	}
+	caKey, err := parsePrivateKeyFile(caPath + ".key")
+	if err != nil {
+		return nil, err
+	}
+
+	return &authority.CertificateAuthority{
+		Certificate: caCert,
+		PrivateKey:  caKey,
+		Now:         func() time.Time { return now },
+	}, nil
+}
+
+func parsePrivateKeyFile(path string) (crypto.Signer, error) {
+	keyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %q: %w", path, err)
+	}
+	return key, nil
+}
+
+func parsePrivateKeyPEM(keyPEM []byte) (crypto.Signer, error) {
+	key, err := keyutil.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key does not implement crypto.Signer")
+	}
+	return signer, nil
+}
+
+func currentAuthInfo(config *clientcmdapi.Config, kubeconfigPath string) (*clientcmdapi.AuthInfo, error) {
+	context, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("failed to find current context %q in kubeconfig %q", config.CurrentContext, kubeconfigPath)
+	}
+	authInfo, ok := config.AuthInfos[context.AuthInfo]
+	if !ok {
+		return nil, fmt.Errorf("failed to find user %q in kubeconfig %q", context.AuthInfo, kubeconfigPath)
+	}
+	return authInfo, nil
+}
+
+// writeFileAtomic writes the file through a temporary file in the same folder
+// and renames it, the file mode of the existing file is kept
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,198 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/keyutil"
+
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+// writeTestCertificate writes the PEM encoded certificate and key files,
+// the certificate is signed by `parent` or self-signed
+func writeTestCertificate(t *testing.T, path string, tmpl, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path+".crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".key", keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return c, key
+}
+
+func TestRenewCertificate(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	notBefore := now.Add(-360 * 24 * time.Hour)
+
+	ca, caKey := writeTestCertificate(t, filepath.Join(dir, "ca"), &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}, nil, nil)
+
+	old, _ := writeTestCertificate(t, filepath.Join(dir, "apiserver"), &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "kube-apiserver"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(365 * 24 * time.Hour),
+		DNSNames:     []string{"kubernetes", "kubernetes.default"},
+		IPAddresses:  []net.IP{net.ParseIP("10.96.0.1").To4()},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	oldKeyPEM, err := os.ReadFile(filepath.Join(dir, "apiserver.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin, adminKey := writeTestCertificate(t, filepath.Join(dir, "admin"), &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "kubernetes-admin", Organization: []string{"system:masters"}},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	adminKeyPEM, err := keyutil.MarshalPrivateKeyToPEM(adminKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminCertPEM, err := os.ReadFile(filepath.Join(dir, "admin.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["kubernetes"] = &clientcmdapi.Cluster{Server: "https://127.0.0.1:6443"}
+	kubeconfig.AuthInfos["kubernetes-admin"] = &clientcmdapi.AuthInfo{ClientCertificateData: adminCertPEM, ClientKeyData: adminKeyPEM}
+	kubeconfig.Contexts["kubernetes-admin@kubernetes"] = &clientcmdapi.Context{Cluster: "kubernetes", AuthInfo: "kubernetes-admin"}
+	kubeconfig.CurrentContext = "kubernetes-admin@kubernetes"
+	if err := clientcmd.WriteToFile(*kubeconfig, filepath.Join(dir, "admin.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		parse    func(path string) (*x509.Certificate, error)
+		expected *x509.Certificate
+	}{
+		{
+			name:     "certificate",
+			path:     filepath.Join(dir, "apiserver.crt"),
+			parse:    cert.ParseCertificateFile,
+			expected: old,
+		},
+		{
+			name:     "kubeconfig",
+			path:     filepath.Join(dir, "admin.conf"),
+			parse:    cert.ParseKubeconfigFile,
+			expected: admin,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if err := renewCertificate(tt.path, filepath.Join(dir, "ca"), KeyReuse, subjectAltNames{}, now); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			renewed, err := tt.parse(tt.path)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := renewed.CheckSignatureFrom(ca); err != nil {
+				t.Errorf("expected the certificate signed by the CA, got %v", err)
+			}
+			if !renewed.NotBefore.Equal(now) || !renewed.NotAfter.Equal(now.Add(365*24*time.Hour)) {
+				t.Errorf("expected validity from %v for 1 year, got %v to %v", now, renewed.NotBefore, renewed.NotAfter)
+			}
+			if renewed.Subject.String() != tt.expected.Subject.String() {
+				t.Errorf("expected subject %v, got %v", tt.expected.Subject, renewed.Subject)
+			}
+			if !reflect.DeepEqual(renewed.DNSNames, tt.expected.DNSNames) || !reflect.DeepEqual(renewed.IPAddresses, tt.expected.IPAddresses) {
+				t.Errorf("expected SANs %v %v, got %v %v", tt.expected.DNSNames, tt.expected.IPAddresses, renewed.DNSNames, renewed.IPAddresses)
+			}
+			if renewed.KeyUsage != tt.expected.KeyUsage || !reflect.DeepEqual(renewed.ExtKeyUsage, tt.expected.ExtKeyUsage) {
+				t.Errorf("expected usages %v %v, got %v %v", tt.expected.KeyUsage, tt.expected.ExtKeyUsage, renewed.KeyUsage, renewed.ExtKeyUsage)
+			}
+			if !reflect.DeepEqual(renewed.PublicKey, tt.expected.PublicKey) {
+				t.Error("expected the private key to be kept")
+			}
+		})
+	}
+
+	keyPEM, err := os.ReadFile(filepath.Join(dir, "apiserver.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(keyPEM, oldKeyPEM) {
+		t.Error("expected the key file to be unchanged")
+	}
+	info, err := os.Stat(filepath.Join(dir, "apiserver.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("expected the certificate file mode 0644, got %v", info.Mode().Perm())
+	}
+}
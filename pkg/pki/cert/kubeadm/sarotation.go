@@ -0,0 +1,173 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/util/keyutil"
+
+	"github.com/jenting/kucero/pkg/host"
+)
+
+const (
+	// ServiceAccountKey is the rotation name of the service account signing key
+	// rotated with the CA rotation phases:
+	// trust verifies the tokens with the old and the new public keys,
+	// reissue signs the tokens with the new private key,
+	// finalize stops verifying the tokens with the old public key
+	ServiceAccountKey = "sa"
+)
+
+// ValidateRotation validates the name of the CA or of the service account signing key to rotate
+func ValidateRotation(name string) error {
+	if name == ServiceAccountKey {
+		return nil
+	}
+	return ValidateCertificateAuthority(name)
+}
+
+// ServiceAccountKeyModTime returns the time the service account signing key
+// was last written on the node
+func (r *CARotation) ServiceAccountKeyModTime() (time.Time, error) {
+	inv := discoverInventory(r.client, r.kubeadmConfigPath)
+	info, err := os.Stat(filepath.Join(inv.certificatesDir, ServiceAccountKey+".key"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// prepareServiceAccountKey generates the new service account signing key
+// with the key algorithm of the current key
+// returns the PEM encoded public key and private key
+func (r *CARotation) prepareServiceAccountKey(certificatesDir string) ([]byte, []byte, error) {
+	old, err := parsePrivateKeyFile(filepath.Join(certificatesDir, ServiceAccountKey+".key"))
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := generateKey(keyAlgorithmOf(old.Public()))
+	if err != nil {
+		return nil, nil, err
+	}
+	pubPEM, err := encodePublicKeys(key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := marshalPrivateKeyPEM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pubPEM, keyPEM, nil
+}
+
+// rotateServiceAccountKey runs the rotation phase of the service account signing key
+// on the control plane node, then restarts the static pods using the key
+func (r *CARotation) rotateServiceAccountKey(phase, certificatesDir string, pubPEM, keyPEM []byte) error {
+	pubs, err := keyutil.ParsePublicKeysPEM(pubPEM)
+	if err != nil {
+		return fmt.Errorf("failed to parse the new service account public key: %w", err)
+	}
+	newPub := pubs[0]
+
+	pubPath := filepath.Join(certificatesDir, ServiceAccountKey+".pub")
+	keyPath := filepath.Join(certificatesDir, ServiceAccountKey+".key")
+	current, err := keyutil.PublicKeysFromFile(pubPath)
+	if err != nil {
+		return err
+	}
+	olds := []crypto.PublicKey{}
+	for _, pub := range current {
+		if !equalPublicKey(pub, newPub) {
+			olds = append(olds, pub)
+		}
+	}
+	for _, path := range []string{pubPath, keyPath} {
+		if _, err := backupCertificate(r.nodeName, ServiceAccountKey, path); err != nil {
+			return err
+		}
+	}
+
+	// kube-apiserver verifies the tokens with every public key of --service-account-key-file
+	var staticPods []string
+	switch phase {
+	case CAPhaseTrust:
+		if len(olds) == 0 {
+			return fmt.Errorf("no service account public key other than the new key found in %s", pubPath)
+		}
+		err = writePublicKeys(pubPath, append(olds, newPub)...)
+		staticPods = []string{"kube-apiserver"}
+	case CAPhaseReissue:
+		err = writeFileAtomic(keyPath, keyPEM)
+		staticPods = []string{"kube-apiserver", "kube-controller-manager"}
+	case CAPhaseFinalize:
+		err = writePublicKeys(pubPath, newPub)
+		staticPods = []string{"kube-apiserver"}
+	default:
+		err = fmt.Errorf("invalid service account key rotation phase %q to run on the node", phase)
+	}
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, staticPod := range staticPods {
+		if err := host.RestartStaticPod(r.client, r.nodeName, staticPod, r.staticPodRestartTimeout); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	if errs == nil {
+		logrus.Infof("The %s node service account key rotation phase %s is done", r.nodeName, phase)
+	}
+	return errs
+}
+
+// writePublicKeys writes the PEM encoded public keys bundle atomically
+func writePublicKeys(path string, pubs ...crypto.PublicKey) error {
+	data, err := encodePublicKeys(pubs...)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// encodePublicKeys returns the PEM encoded public keys bundle, the way kubeadm writes sa.pub
+func encodePublicKeys(pubs ...crypto.PublicKey) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, pub := range pubs {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		_ = pem.Encode(&buf, &pem.Block{Type: keyutil.PublicKeyBlockType, Bytes: der})
+	}
+	return buf.Bytes(), nil
+}
+
+func equalPublicKey(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
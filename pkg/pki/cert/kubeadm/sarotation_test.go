@@ -0,0 +1,82 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/client-go/util/keyutil"
+)
+
+func TestPrepareServiceAccountKey(t *testing.T) {
+	dir := t.TempDir()
+	old, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPEM, err := keyutil.MarshalPrivateKeyToPEM(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sa.key"), oldPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePublicKeys(filepath.Join(dir, "sa.pub"), old.Public()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &CARotation{}
+	pubPEM, keyPEM, err := r.prepareServiceAccountKey(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := parsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok || rsaKey.N.BitLen() != 2048 {
+		t.Fatalf("expected the RSA 2048 key algorithm of the old key, got %T", key)
+	}
+	if rsaKey.Equal(old) {
+		t.Error("expected a new key")
+	}
+
+	pubs, err := keyutil.ParsePublicKeysPEM(pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pubs) != 1 || !equalPublicKey(pubs[0], key.Public()) {
+		t.Errorf("expected the public key of the new key, got %v", pubs)
+	}
+
+	// the trust bundle holds the old and the new public keys
+	if err := writePublicKeys(filepath.Join(dir, "sa.pub"), old.Public(), key.Public()); err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := keyutil.PublicKeysFromFile(filepath.Join(dir, "sa.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle) != 2 || !equalPublicKey(bundle[0], old.Public()) || !equalPublicKey(bundle[1], key.Public()) {
+		t.Errorf("expected the old and the new public keys, got %v", bundle)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+// staticPods are the control plane static pods
+// in the order to restart them
+var staticPods []string = []string{
+	"etcd",
+	"kube-apiserver",
+	"kube-controller-manager",
+	"kube-scheduler",
+}
+
+// certificateStaticPods maps the certificate to the static pods serving it,
+// admin.conf and etcd-healthcheck-client are not used by any static pod
+var certificateStaticPods map[string][]string = map[string][]string{
+	"controller-manager.conf":  {"kube-controller-manager"},
+	"scheduler.conf":           {"kube-scheduler"},
+	"apiserver":                {"kube-apiserver"},
+	"apiserver-etcd-client":    {"kube-apiserver"},
+	"apiserver-kubelet-client": {"kube-apiserver"},
+	"front-proxy-client":       {"kube-apiserver"},
+	"etcd-peer":                {"etcd"},
+	"etcd-server":              {"etcd"},
+}
+
+// authorityStaticPods maps the CA to the static pods trusting it
+var authorityStaticPods map[string][]string = map[string][]string{
+	"ca":             {"kube-apiserver", "kube-controller-manager", "kube-scheduler"},
+	"front-proxy-ca": {"kube-apiserver"},
+	"etcd-ca":        {"etcd", "kube-apiserver"},
+}
+
+// StaticPods returns the static pods to be restarted
+// after renewing the certificates
+func StaticPods(certificateNames []string) []string {
+	restart := map[string]bool{}
+	for _, certificateName := range certificateNames {
+		for _, staticPod := range certificateStaticPods[certificateName] {
+			restart[staticPod] = true
+		}
+	}
+	return orderStaticPods(restart)
+}
+
+// orderStaticPods returns the static pods to be restarted in the restart order
+func orderStaticPods(restart map[string]bool) []string {
+	pods := []string{}
+	for _, staticPod := range staticPods {
+		if restart[staticPod] {
+			pods = append(pods, staticPod)
+		}
+	}
+	return pods
+}
@@ -0,0 +1,61 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStaticPods(t *testing.T) {
+	tests := []struct {
+		name             string
+		certificateNames []string
+		expected         []string
+	}{
+		{
+			name:             "no certificates",
+			certificateNames: []string{},
+			expected:         []string{},
+		},
+		{
+			name:             "certificates not used by static pods",
+			certificateNames: []string{"admin.conf", "etcd-healthcheck-client"},
+			expected:         []string{},
+		},
+		{
+			name:             "apiserver certificates restart kube-apiserver once",
+			certificateNames: []string{"apiserver", "front-proxy-client", "apiserver-kubelet-client"},
+			expected:         []string{"kube-apiserver"},
+		},
+		{
+			name:             "all certificates restart in order",
+			certificateNames: []string{"scheduler.conf", "controller-manager.conf", "apiserver", "etcd-server", "admin.conf"},
+			expected:         []string{"etcd", "kube-apiserver", "kube-controller-manager", "kube-scheduler"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got := StaticPods(tt.certificateNames)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+const verifyPollInterval = 5 * time.Second
+
+// servingPorts maps the serving certificate
+// to the port of the static pod serving it
+var servingPorts map[string]string = map[string]string{
+	"apiserver":   "6443",
+	"etcd-server": "2379",
+}
+
+// verifyServingCertificates waits for the apiserver and etcd on `host`
+// to present the serving certificates on the host system found in `certificates`,
+// the certificates which do not exist on the host system are skipped, e.g. external etcd
+func verifyServingCertificates(host string, certificates map[string]string, timeout time.Duration) error {
+	names := []string{}
+	for name := range servingPorts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs error
+	for _, name := range names {
+		path, ok := certificates[name]
+		if !ok {
+			logrus.Warnf("Skip verifying %s serving certificate: not found", name)
+			continue
+		}
+		expected, err := cert.ParseCertificateFile(path)
+		if err != nil {
+			logrus.Warnf("Skip verifying %s serving certificate: %v", name, err)
+			continue
+		}
+
+		address := net.JoinHostPort(host, servingPorts[name])
+		logrus.Infof("Waiting for %s to serve the certificate %s", address, name)
+		err = wait.PollUntilContextTimeout(context.TODO(), verifyPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+			served, err := servingCertificate(address, verifyPollInterval)
+			if err != nil {
+				logrus.Debugf("Error getting %s serving certificate: %v", address, err)
+				return false, nil
+			}
+			return bytes.Equal(served.Raw, expected.Raw), nil
+		})
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s does not serve the certificate %s after %s: %w", address, name, timeout, err))
+		}
+	}
+
+	return errs
+}
+
+// servingCertificate returns the certificate presented by the TLS server at `address`,
+// the certificate is captured during the handshake since the server might require a client certificate
+func servingCertificate(address string, timeout time.Duration) (*x509.Certificate, error) {
+	var served *x509.Certificate
+	config := &tls.Config{
+		// the serving certificate is compared with the certificate on the host system
+		InsecureSkipVerify: true, // nolint:gosec
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) > 0 {
+				served = state.PeerCertificates[0]
+			}
+			return nil
+		},
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, config)
+	if conn != nil {
+		conn.Close()
+	}
+	if served != nil {
+		return served, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no certificate presented")
+}
@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServingCertificate(t *testing.T) {
+	tests := []struct {
+		name              string
+		requireClientCert bool
+	}{
+		{
+			name: "server without client authentication",
+		},
+		{
+			name:              "server requires client certificate",
+			requireClientCert: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			server.TLS = &tls.Config{}
+			if tt.requireClientCert {
+				server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			server.StartTLS()
+			defer server.Close()
+
+			served, err := servingCertificate(server.Listener.Addr().String(), time.Second)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !bytes.Equal(served.Raw, server.Certificate().Raw) {
+				t.Errorf("expected the server certificate %v, got %v", server.Certificate().Subject, served.Subject)
+			}
+		})
+	}
+}
+
+func TestVerifyServingCertificatesReportsEveryFailure(t *testing.T) {
+	dir := t.TempDir()
+	certificates := map[string]string{}
+	ports := map[string]string{}
+	for _, name := range []string{"apiserver", "etcd-server"} {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+		_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ports[name] = port
+
+		// the server presents a certificate other than the one on the host system
+		certificates[name] = filepath.Join(dir, name+".crt")
+		data := newTestCertificate(t, name, time.Now(), time.Now().Add(time.Hour), nil, nil)
+		if err := os.WriteFile(certificates[name], data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defaultPorts := servingPorts
+	servingPorts = ports
+	defer func() { servingPorts = defaultPorts }()
+
+	err := verifyServingCertificates("127.0.0.1", certificates, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	for _, name := range []string{"apiserver", "etcd-server"} {
+		if !strings.Contains(err.Error(), "certificate "+name+" ") {
+			t.Errorf("expected the %s failure reported, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubelet
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/jenting/kucero/pkg/host"
+)
+
+const (
+	// BootstrapTokenRequestAnnotation holds the base64 encoded public key
+	// the node whose client certificate expired requests a bootstrap token with
+	BootstrapTokenRequestAnnotation = "caasp.suse.com/kucero-bootstrap-token-request"
+	// BootstrapTokenAnnotation holds the bootstrap token issued by the control plane,
+	// encrypted with the requested public key and base64 encoded
+	BootstrapTokenAnnotation = "caasp.suse.com/kucero-bootstrap-token"
+
+	// bootstrapTokenGroup is the group kubeadm grants
+	// to create and auto-approve the node client CSRs
+	bootstrapTokenGroup = "system:bootstrappers:kubeadm:default-node-token"
+	// bootstrapTokenTTL is the lifetime of the bootstrap token,
+	// long enough for kubelet to request its client certificate
+	bootstrapTokenTTL = 15 * time.Minute
+	// bootstrapTokenRequestTimeout is the time the node waits for the control plane to issue the bootstrap token
+	bootstrapTokenRequestTimeout = 5 * time.Minute
+	bootstrapTokenPollInterval   = 2 * time.Second
+	// bootstrapTokenLabel binds the encrypted bootstrap token to its purpose
+	bootstrapTokenLabel = "kucero-bootstrap-token"
+
+	bootstrapTokenNamespace = "kube-system"
+	bootstrapTokenAlphabet  = "0123456789abcdefghijklmnopqrstuvwxyz"
+)
+
+// requestBootstrapToken requests a bootstrap token from the kucero controller on the control plane,
+// the node annotates itself with a new public key and waits for the token encrypted with it,
+// the worker nodes cannot create bootstrap tokens themselves
+// returns the token `<token-id>.<token-secret>`
+func requestBootstrapToken(client kubernetes.Interface, nodeName string, timeout time.Duration) (string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	// a token encrypted with the public key of a previous request cannot be decrypted
+	if err := host.RemoveAnnotations(client, nodeName, BootstrapTokenAnnotation); err != nil {
+		return "", err
+	}
+	if err := host.Annotate(client, nodeName, map[string]string{BootstrapTokenRequestAnnotation: base64.StdEncoding.EncodeToString(der)}); err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = host.RemoveAnnotations(client, nodeName, BootstrapTokenRequestAnnotation, BootstrapTokenAnnotation)
+	}()
+	logrus.Infof("Waiting for the control plane to issue a bootstrap token for %s node", nodeName)
+
+	var token string
+	err = wait.PollUntilContextTimeout(context.TODO(), bootstrapTokenPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		corev1Node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			logrus.Debugf("Error getting %s node: %v", nodeName, err)
+			return false, nil
+		}
+		encrypted := corev1Node.GetAnnotations()[BootstrapTokenAnnotation]
+		if encrypted == "" {
+			return false, nil
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
+		if err != nil {
+			return false, fmt.Errorf("failed to decode the bootstrap token: %w", err)
+		}
+		plaintext, err := rsa.DecryptOAEP(sha256.New(), nil, key, ciphertext, []byte(bootstrapTokenLabel))
+		if err != nil {
+			return false, fmt.Errorf("failed to decrypt the bootstrap token: %w", err)
+		}
+		token = string(plaintext)
+		return true, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("no bootstrap token issued for node %s: %w", nodeName, err)
+	}
+	return token, nil
+}
+
+// IssueBootstrapToken creates a bootstrap token for the node requesting it,
+// only valid for authentication and for bootstrapTokenTTL
+// returns the token encrypted with the requested public key, base64 encoded
+func IssueBootstrapToken(client kubernetes.Interface, nodeName, request string, now time.Time) (string, error) {
+	der, err := base64.StdEncoding.DecodeString(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode node %s bootstrap token request: %w", nodeName, err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse node %s bootstrap token request: %w", nodeName, err)
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return "", errors.New("the bootstrap token request public key is not a RSA key")
+	}
+
+	token, err := createBootstrapToken(client, nodeName, now)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaPub, []byte(token), []byte(bootstrapTokenLabel))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// createBootstrapToken creates a short-lived bootstrap token Secret
+// for the node to request a new client certificate
+// returns the token `<token-id>.<token-secret>`
+func createBootstrapToken(client kubernetes.Interface, nodeName string, now time.Time) (string, error) {
+	id, err := randomString(6)
+	if err != nil {
+		return "", err
+	}
+	secret, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = client.CoreV1().Secrets(bootstrapTokenNamespace).Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bootstrap-token-" + id,
+			Namespace: bootstrapTokenNamespace,
+		},
+		Type: corev1.SecretTypeBootstrapToken,
+		StringData: map[string]string{
+			"description":                    fmt.Sprintf("kucero re-bootstrap of node %s", nodeName),
+			"token-id":                       id,
+			"token-secret":                   secret,
+			"expiration":                     now.Add(bootstrapTokenTTL).UTC().Format(time.RFC3339),
+			"usage-bootstrap-authentication": "true",
+			"auth-extra-groups":              bootstrapTokenGroup,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to create bootstrap token for node %s: %w", nodeName, err)
+	}
+
+	return id + "." + secret, nil
+}
+
+// newBootstrapKubeconfig returns the bootstrap kubeconfig authenticating with the token
+// to the apiserver of the kubelet kubeconfig current context
+func newBootstrapKubeconfig(kubeletKubeconfigPath, token string) ([]byte, error) {
+	config, err := clientcmd.LoadFromFile(kubeletKubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	context, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("failed to find current context %q in kubeconfig %q", config.CurrentContext, kubeletKubeconfigPath)
+	}
+	cluster, ok := config.Clusters[context.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("failed to find cluster %q in kubeconfig %q", context.Cluster, kubeletKubeconfigPath)
+	}
+
+	bootstrap := clientcmdapi.NewConfig()
+	bootstrap.Clusters[context.Cluster] = cluster
+	bootstrap.AuthInfos["tls-bootstrap-token-user"] = &clientcmdapi.AuthInfo{Token: token}
+	bootstrap.Contexts["tls-bootstrap-token-user@"+context.Cluster] = &clientcmdapi.Context{
+		Cluster:  context.Cluster,
+		AuthInfo: "tls-bootstrap-token-user",
+	}
+	bootstrap.CurrentContext = "tls-bootstrap-token-user@" + context.Cluster
+
+	return clientcmd.Write(*bootstrap)
+}
+
+// randomString returns a cryptographically random string of the bootstrap token alphabet
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(bootstrapTokenAlphabet)))
+	for i := range b {
+		r, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = bootstrapTokenAlphabet[r.Int64()]
+	}
+	return string(b), nil
+}
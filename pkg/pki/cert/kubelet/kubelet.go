@@ -0,0 +1,280 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubelet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/pki/cert"
+	"github.com/jenting/kucero/pkg/pki/clock"
+)
+
+const (
+	// ClientCertificate is the kubelet client certificate name
+	ClientCertificate = "kubelet-client"
+	// ServerCertificate is the kubelet serving certificate name
+	ServerCertificate = "kubelet-server"
+	// ClientCertificatePath is the current kubelet client certificate and key file
+	ClientCertificatePath = "/var/lib/kubelet/pki/kubelet-client-current.pem"
+
+	// kubeletKubeconfig is the kubeconfig kubelet connects to the apiserver with
+	kubeletKubeconfig = "/etc/kubernetes/kubelet.conf"
+	// bootstrapKubeconfig is the kubeconfig kubelet bootstraps its client certificate with
+	bootstrapKubeconfig = "/etc/kubernetes/bootstrap-kubelet.conf"
+
+	verifyPollInterval = 5 * time.Second
+)
+
+// certificates maps the kubelet certificate name to
+// the current certificate rotated by kubelet
+var certificates map[string]string = map[string]string{
+	ClientCertificate: ClientCertificatePath,
+	ServerCertificate: "/var/lib/kubelet/pki/kubelet-server-current.pem",
+}
+
+// Kubelet is the certificate backend of the worker nodes,
+// kubelet rotates its certificates itself, kucero restarts kubelet
+// if it did not, and re-bootstraps the node if the client certificate expired
+type Kubelet struct {
+	nodeName     string
+	client       kubernetes.Interface
+	expiryPolicy cert.ExpiryPolicy
+	clock        clock.Clock
+
+	// backups are the backup files taken by the last rotation
+	backups []backup
+	// bootstrapped is true if the last rotation re-bootstrapped the node
+	bootstrapped bool
+}
+
+// New returns the kubelet certificate instance
+func New(nodeName string, client kubernetes.Interface, expiryPolicy cert.ExpiryPolicy) cert.Certificate {
+	return &Kubelet{
+		nodeName:     nodeName,
+		client:       client,
+		expiryPolicy: expiryPolicy,
+		clock:        clock.NewRealClock(),
+	}
+}
+
+// Inspect reads the kubelet client and serving certificates on the host system
+func (k *Kubelet) Inspect() ([]cert.Info, error) {
+	return inspectCertificates(certificates)
+}
+
+// CheckExpiration checks the kubelet certificates
+// returns the certificates which are going to expires
+func (k *Kubelet) CheckExpiration() ([]string, error) {
+	logrus.Infof("Commanding check %s node kubelet certificate expiration", k.nodeName)
+
+	expiryCertificates := []string{}
+	infos, err := k.Inspect()
+	if err != nil {
+		return expiryCertificates, err
+	}
+
+	now := k.clock.Now()
+	for _, info := range infos {
+		if k.expiryPolicy.CheckExpiry(info, now) {
+			expiryCertificates = append(expiryCertificates, info.Name)
+		}
+	}
+
+	return expiryCertificates, nil
+}
+
+// Rotate re-bootstraps the node if the client certificate expired,
+// since kubelet cannot rotate it anymore, otherwise restarts kubelet
+// to make it rotate the certificates it did not
+func (k *Kubelet) Rotate(expiryCertificates []string) error {
+	k.backups = []backup{}
+	k.bootstrapped = false
+
+	c, err := cert.ParseCertificateFile(certificates[ClientCertificate])
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if c == nil || !k.clock.Now().Before(c.NotAfter) {
+		return k.bootstrap()
+	}
+
+	logrus.Infof("Commanding kubelet to rotate %s node certificates %v", k.nodeName, expiryCertificates)
+	return host.RestartKubelet(k.nodeName)
+}
+
+// Verify waits for kubelet to have a valid client certificate
+// if the node was re-bootstrapped
+func (k *Kubelet) Verify(timeout time.Duration) error {
+	if !k.bootstrapped {
+		return nil
+	}
+	logrus.Infof("Waiting for %s node kubelet to bootstrap its client certificate", k.nodeName)
+
+	err := wait.PollUntilContextTimeout(context.TODO(), verifyPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		c, err := cert.ParseCertificateFile(certificates[ClientCertificate])
+		if err != nil {
+			logrus.Debugf("Error reading kubelet client certificate: %v", err)
+			return false, nil
+		}
+		return k.clock.Now().Before(c.NotAfter), nil
+	})
+	if err != nil {
+		return fmt.Errorf("kubelet has no valid client certificate on %s node after %s: %w", k.nodeName, timeout, err)
+	}
+	return nil
+}
+
+// Rollback restores the files moved by the last rotation and restarts kubelet
+func (k *Kubelet) Rollback() error {
+	logrus.Infof("Commanding rollback %s node kubelet certificate rotation", k.nodeName)
+
+	var errs error
+	for _, b := range k.backups {
+		if err := moveFile(k.nodeName, b.backupPath, b.path); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+
+	return host.RestartKubelet(k.nodeName)
+}
+
+// Restart returns nil, kubelet is the only component using the certificates
+func (k *Kubelet) Restart(certificateNames []string) error {
+	return nil
+}
+
+// Backups returns the backup files taken by the last rotation
+func (k *Kubelet) Backups() []string {
+	paths := []string{}
+	for _, b := range k.backups {
+		paths = append(paths, b.backupPath)
+	}
+	return paths
+}
+
+// bootstrap re-bootstraps the node whose client certificate expired
+func (k *Kubelet) bootstrap() error {
+	logrus.Warnf("The %s node kubelet client certificate expired, re-bootstrapping the node", k.nodeName)
+
+	backupPath, err := rebootstrap(k.client, k.nodeName, k.clock.Now())
+	if backupPath != "" {
+		k.backups = append(k.backups, backup{path: certificates[ClientCertificate], backupPath: backupPath})
+	}
+	if err != nil {
+		return err
+	}
+	k.bootstrapped = true
+
+	return nil
+}
+
+// rebootstrap requests a bootstrap token from the control plane, writes the bootstrap kubeconfig,
+// moves the client certificate away and restarts kubelet
+// so it requests a new client certificate and the node rejoins
+// returns the backup of the moved client certificate, empty if there was none
+func rebootstrap(client kubernetes.Interface, nodeName string, now time.Time) (string, error) {
+	if client == nil {
+		return "", errors.New("re-bootstrapping the node requires API access")
+	}
+
+	token, err := requestBootstrapToken(client, nodeName, bootstrapTokenRequestTimeout)
+	if err != nil {
+		return "", err
+	}
+	kubeconfig, err := newBootstrapKubeconfig(kubeletKubeconfig, token)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(bootstrapKubeconfig, kubeconfig, 0600); err != nil {
+		return "", err
+	}
+
+	var backupPath string
+	path := certificates[ClientCertificate]
+	if _, err := os.Lstat(path); err == nil {
+		backupPath = path + "-" + now.Format("20060102030405") + ".bak"
+		if err := moveFile(nodeName, path, backupPath); err != nil {
+			return "", err
+		}
+	}
+
+	return backupPath, host.RestartKubelet(nodeName)
+}
+
+// backup is a file moved away by the rotation
+type backup struct {
+	path       string
+	backupPath string
+}
+
+// moveFile moves the file on the host system, keeping symbolic links
+func moveFile(nodeName, from, to string) error {
+	logrus.Infof("Commanding move %s node file %s to %s", nodeName, from, to)
+
+	// Relies on hostPID:true and privileged:true to enter host mount space
+	cmd := host.NewCommand("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/mv", "-f", from, to)
+	err := cmd.Run()
+	if err != nil {
+		logrus.Errorf("Error invoking %s: %v", cmd.Args, err)
+	}
+
+	return err
+}
+
+// inspectCertificates reads the kubelet certificates,
+// the certificates which do not exist are skipped,
+// e.g. the serving certificate without serverTLSBootstrap
+// returns the certificates information sorted by certificate name
+func inspectCertificates(certificates map[string]string) ([]cert.Info, error) {
+	infos := []cert.Info{}
+
+	names := make([]string, 0, len(certificates))
+	for name := range certificates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := certificates[name]
+
+		c, err := cert.ParseCertificateFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			logrus.Debugf("The certificate %s path %s does not exist", name, path)
+			continue
+		}
+		if err != nil {
+			return infos, fmt.Errorf("failed to inspect certificate %s: %w", name, err)
+		}
+
+		infos = append(infos, cert.NewInfo(name, path, c))
+	}
+
+	return infos, nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubelet
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/jenting/kucero/pkg/host"
+)
+
+func Test_inspectCertificates(t *testing.T) {
+	dir := t.TempDir()
+	notAfter := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "system:node:worker", Organization: []string{"system:nodes"}},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// kubelet-client-current.pem bundles the certificate and the key
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...)
+	if err := os.WriteFile(filepath.Join(dir, "kubelet-client-2020.pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "kubelet-client-2020.pem"), filepath.Join(dir, "kubelet-client-current.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := inspectCertificates(map[string]string{
+		ClientCertificate: filepath.Join(dir, "kubelet-client-current.pem"),
+		ServerCertificate: filepath.Join(dir, "kubelet-server-current.pem"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("expected the client certificate only, got %v", infos)
+	}
+	if infos[0].Name != ClientCertificate || !infos[0].NotAfter.Equal(notAfter) {
+		t.Errorf("expected %s expiring at %v, got %s expiring at %v", ClientCertificate, notAfter, infos[0].Name, infos[0].NotAfter)
+	}
+}
+
+func Test_createBootstrapToken(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	token, err := createBootstrapToken(client, "worker", now)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || len(parts[0]) != 6 || len(parts[1]) != 16 {
+		t.Fatalf("expected token <6 chars>.<16 chars>, got %q", token)
+	}
+
+	secret, err := client.CoreV1().Secrets(bootstrapTokenNamespace).Get(context.TODO(), "bootstrap-token-"+parts[0], metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the bootstrap token secret, got %v", err)
+	}
+	if secret.Type != corev1.SecretTypeBootstrapToken {
+		t.Errorf("expected secret type %s, got %s", corev1.SecretTypeBootstrapToken, secret.Type)
+	}
+	expected := map[string]string{
+		"token-id":                       parts[0],
+		"token-secret":                   parts[1],
+		"expiration":                     "2021-01-01T00:15:00Z",
+		"usage-bootstrap-authentication": "true",
+		"auth-extra-groups":              bootstrapTokenGroup,
+	}
+	for k, v := range expected {
+		if secret.StringData[k] != v {
+			t.Errorf("expected %s %q, got %q", k, v, secret.StringData[k])
+		}
+	}
+}
+
+func Test_requestBootstrapToken(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker", Annotations: map[string]string{BootstrapTokenAnnotation: "stale"}},
+	})
+
+	// the kucero controller on the control plane issues the requested token
+	go func() {
+		for {
+			node, err := client.CoreV1().Nodes().Get(context.TODO(), "worker", metav1.GetOptions{})
+			request := ""
+			if err == nil {
+				request = node.GetAnnotations()[BootstrapTokenRequestAnnotation]
+			}
+			if request == "" {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			encrypted, err := IssueBootstrapToken(client, "worker", request, time.Now())
+			if err != nil {
+				return
+			}
+			_ = host.Annotate(client, "worker", map[string]string{BootstrapTokenAnnotation: encrypted})
+			return
+		}
+	}()
+
+	token, err := requestBootstrapToken(client, "worker", 10*time.Second)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		t.Fatalf("expected token <token-id>.<token-secret>, got %q", token)
+	}
+	secret, err := client.CoreV1().Secrets(bootstrapTokenNamespace).Get(context.TODO(), "bootstrap-token-"+parts[0], metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the bootstrap token secret, got %v", err)
+	}
+	if secret.StringData["token-secret"] != parts[1] {
+		t.Errorf("expected token secret %q, got %q", secret.StringData["token-secret"], parts[1])
+	}
+
+	node, err := client.CoreV1().Nodes().Get(context.TODO(), "worker", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, annotation := range []string{BootstrapTokenRequestAnnotation, BootstrapTokenAnnotation} {
+		if _, ok := node.GetAnnotations()[annotation]; ok {
+			t.Errorf("expected the %s annotation removed, got %v", annotation, node.GetAnnotations())
+		}
+	}
+}
+
+func Test_newBootstrapKubeconfig(t *testing.T) {
+	kubeletConfig := clientcmdapi.NewConfig()
+	kubeletConfig.Clusters["kubernetes"] = &clientcmdapi.Cluster{Server: "https://10.0.0.1:6443", CertificateAuthorityData: []byte("ca")}
+	kubeletConfig.AuthInfos["system:node:worker"] = &clientcmdapi.AuthInfo{ClientCertificate: "/var/lib/kubelet/pki/kubelet-client-current.pem"}
+	kubeletConfig.Contexts["system:node:worker@kubernetes"] = &clientcmdapi.Context{Cluster: "kubernetes", AuthInfo: "system:node:worker"}
+	kubeletConfig.CurrentContext = "system:node:worker@kubernetes"
+	path := filepath.Join(t.TempDir(), "kubelet.conf")
+	if err := clientcmd.WriteToFile(*kubeletConfig, path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := newBootstrapKubeconfig(path, "abcdef.0123456789abcdef")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := config.Contexts[config.CurrentContext]
+	if config.Clusters[context.Cluster].Server != "https://10.0.0.1:6443" || string(config.Clusters[context.Cluster].CertificateAuthorityData) != "ca" {
+		t.Errorf("expected the kubelet cluster, got %v", config.Clusters[context.Cluster])
+	}
+	if config.AuthInfos[context.AuthInfo].Token != "abcdef.0123456789abcdef" {
+		t.Errorf("expected the bootstrap token, got %q", config.AuthInfos[context.AuthInfo].Token)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubelet
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/certificate/csr"
+	"k8s.io/client-go/util/keyutil"
+
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+// RenewClientCertificate requests a new kubelet client certificate
+// with the current one still valid, the way kubelet rotates it,
+// kube-controller-manager approves the node own request and signs it with the cluster CA signer,
+// kubelet uses the new client certificate once restarted
+func RenewClientCertificate(nodeName string, timeout time.Duration) error {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeletKubeconfig)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	return renewClientCertificate(client, nodeName, host.Root, timeout, time.Now())
+}
+
+// renewClientCertificate requests the client certificate with the subject and the private key
+// of the current certificate and key file under `root`, then installs the signed certificate and the key
+func renewClientCertificate(client kubernetes.Interface, nodeName, root string, timeout time.Duration, now time.Time) error {
+	logrus.Infof("Requesting %s node kubelet client certificate", nodeName)
+
+	certificatePath := ResolveClientCertificate(root)
+	data, err := os.ReadFile(certificatePath)
+	if err != nil {
+		return err
+	}
+	old, err := cert.ParseCertificatePEM(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate %q: %w", certificatePath, err)
+	}
+	privateKey, err := keyutil.ParsePrivateKeyPEM(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key %q: %w", certificatePath, err)
+	}
+	key, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("private key %q does not implement crypto.Signer", certificatePath)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: old.Subject}, key)
+	if err != nil {
+		return err
+	}
+	usages := []capi.KeyUsage{capi.UsageDigitalSignature, capi.UsageClientAuth}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		usages = append(usages, capi.UsageKeyEncipherment)
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+	defer cancel()
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+	name, uid, err := csr.RequestCertificateWithContext(ctx, client, csrPEM, "", capi.KubeAPIServerClientKubeletSignerName, nil, usages, key)
+	if err != nil {
+		return fmt.Errorf("failed to request %s node kubelet client certificate: %w", nodeName, err)
+	}
+	certPEM, err := csr.WaitForCertificate(ctx, client, name, uid)
+	if err != nil {
+		return fmt.Errorf("kubelet client certificate CertificateSigningRequest %s is not signed after %s: %w", name, timeout, err)
+	}
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return err
+	}
+
+	if err := UpdateClientCertificate(root, append(certPEM, keyPEM...), now); err != nil {
+		return err
+	}
+	logrus.Infof("The %s node kubelet client certificate is signed by CertificateSigningRequest %s", nodeName, name)
+	return nil
+}
+
+// ResolveClientCertificate returns the file under `root` the current kubelet client certificate
+// symbolic link points to, the symbolic link itself if it cannot be read
+func ResolveClientCertificate(root string) string {
+	path := filepath.Join(root, ClientCertificatePath)
+	target, err := os.Readlink(path)
+	if err != nil {
+		return path
+	}
+	if filepath.IsAbs(target) {
+		return filepath.Join(root, target)
+	}
+	return filepath.Join(filepath.Dir(path), target)
+}
+
+// UpdateClientCertificate writes the kubelet client certificate and key to
+// a new kubelet-client-<timestamp>.pem file under `root`, then points the
+// kubelet-client-current.pem symbolic link to it atomically,
+// the way kubelet stores its rotated client certificate
+func UpdateClientCertificate(root string, data []byte, now time.Time) error {
+	dir := filepath.Dir(ClientCertificatePath)
+	path := filepath.Join(dir, "kubelet-client-"+now.Format("2006-01-02-15-04-05")+".pem")
+	if err := os.WriteFile(filepath.Join(root, path), data, 0600); err != nil {
+		return err
+	}
+
+	updated := filepath.Join(root, dir, "kubelet-client-updated.pem")
+	if err := os.Remove(updated); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(path, updated); err != nil {
+		return err
+	}
+	if err := os.Rename(updated, filepath.Join(root, ClientCertificatePath)); err != nil {
+		_ = os.Remove(updated)
+		return err
+	}
+	return nil
+}
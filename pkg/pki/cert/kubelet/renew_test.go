@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubelet
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	capi "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/keyutil"
+
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+func Test_renewClientCertificate(t *testing.T) {
+	now := time.Now()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject := pkix.Name{CommonName: "system:node:worker", Organization: []string{"system:nodes"}}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      subject,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caTmpl, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// kubelet stores the client certificate behind the kubelet-client-current.pem symbolic link
+	root := t.TempDir()
+	dir := filepath.Join(root, "var", "lib", "kubelet", "pki")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "kubelet-client-2020-01-01-00-00-00.pem"), append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/var/lib/kubelet/pki/kubelet-client-2020-01-01-00-00-00.pem", filepath.Join(dir, "kubelet-client-current.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	// kube-controller-manager approves and signs the node own request
+	client := fake.NewSimpleClientset()
+	go func() {
+		for {
+			requests, err := client.CertificatesV1().CertificateSigningRequests().List(context.TODO(), metav1.ListOptions{})
+			if err != nil || len(requests.Items) == 0 {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			request := requests.Items[0]
+			block, _ := pem.Decode(request.Spec.Request)
+			cr, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil {
+				return
+			}
+			der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+				SerialNumber: big.NewInt(3),
+				Subject:      cr.Subject,
+				NotBefore:    now.Add(-time.Minute),
+				NotAfter:     now.Add(24 * time.Hour),
+				KeyUsage:     x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}, caTmpl, cr.PublicKey, caKey)
+			if err != nil {
+				return
+			}
+			request.Status.Conditions = []capi.CertificateSigningRequestCondition{{Type: capi.CertificateApproved, Status: corev1.ConditionTrue}}
+			request.Status.Certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+			_, _ = client.CertificatesV1().CertificateSigningRequests().UpdateStatus(context.TODO(), &request, metav1.UpdateOptions{})
+			return
+		}
+	}()
+
+	renewedAt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := renewClientCertificate(client, "worker", root, 10*time.Second, renewedAt); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	requests, err := client.CertificatesV1().CertificateSigningRequests().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests.Items) != 1 || requests.Items[0].Spec.SignerName != capi.KubeAPIServerClientKubeletSignerName {
+		t.Errorf("expected a CertificateSigningRequest for signer %s, got %v", capi.KubeAPIServerClientKubeletSignerName, requests.Items)
+	}
+	// kubelet keeps rotating the certificate through the symbolic link
+	target, err := os.Readlink(filepath.Join(dir, "kubelet-client-current.pem"))
+	if err != nil {
+		t.Fatalf("expected kubelet-client-current.pem to stay a symbolic link, got %v", err)
+	}
+	if expected := "/var/lib/kubelet/pki/kubelet-client-2021-01-01-00-00-00.pem"; target != expected {
+		t.Errorf("expected the symbolic link to point to %s, got %s", expected, target)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "kubelet-client-2020-01-01-00-00-00.pem")); err != nil {
+		t.Errorf("expected the previous certificate kept, got %v", err)
+	}
+	data, err := os.ReadFile(ResolveClientCertificate(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cert.ParseCertificatePEM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SerialNumber.Int64() != 3 || c.Subject.CommonName != subject.CommonName || !key.PublicKey.Equal(c.PublicKey) {
+		t.Errorf("expected the signed certificate of %s for the private key, got serial %v subject %v", subject.CommonName, c.SerialNumber, c.Subject)
+	}
+	if _, err := keyutil.ParsePrivateKeyPEM(data); err != nil {
+		t.Errorf("expected the private key kept, got %v", err)
+	}
+}
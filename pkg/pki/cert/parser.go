@@ -20,6 +20,10 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 // ParseCSR decodes a PEM encoded CSR
@@ -36,3 +40,79 @@ func ParseCSR(pemBytes []byte) (*x509.CertificateRequest, error) {
 	}
 	return csr, nil
 }
+
+// ParseCertificatePEM decodes the first PEM encoded certificate
+func ParseCertificatePEM(pemBytes []byte) (*x509.Certificate, error) {
+	for len(pemBytes) > 0 {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		return x509.ParseCertificate(block.Bytes)
+	}
+	return nil, errors.New("no PEM block of type CERTIFICATE found")
+}
+
+// ParseCertificateFile reads and decodes the PEM encoded certificate file
+func ParseCertificateFile(path string) (*x509.Certificate, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := ParseCertificatePEM(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %q: %w", path, err)
+	}
+	return c, nil
+}
+
+// ParseKubeconfigFile reads the kubeconfig file and decodes the client certificate
+// of the current context user, either embedded in client-certificate-data
+// or referenced by client-certificate
+func ParseKubeconfigFile(path string) (*x509.Certificate, error) {
+	config, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	context, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("failed to find current context %q in kubeconfig %q", config.CurrentContext, path)
+	}
+	authInfo, ok := config.AuthInfos[context.AuthInfo]
+	if !ok {
+		return nil, fmt.Errorf("failed to find user %q in kubeconfig %q", context.AuthInfo, path)
+	}
+
+	switch {
+	case len(authInfo.ClientCertificateData) > 0:
+		c, err := ParseCertificatePEM(authInfo.ClientCertificateData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client-certificate-data in kubeconfig %q: %w", path, err)
+		}
+		return c, nil
+	case len(authInfo.ClientCertificate) > 0:
+		return ParseCertificateFile(authInfo.ClientCertificate)
+	default:
+		return nil, fmt.Errorf("no client certificate found in kubeconfig %q", path)
+	}
+}
+
+// NewInfo returns the certificate information of the x509 certificate
+func NewInfo(name, path string, c *x509.Certificate) Info {
+	return Info{
+		Name:        name,
+		Path:        path,
+		NotBefore:   c.NotBefore,
+		NotAfter:    c.NotAfter,
+		Issuer:      c.Issuer.String(),
+		DNSNames:    c.DNSNames,
+		IPAddresses: c.IPAddresses,
+		IsCA:        c.IsCA,
+	}
+}
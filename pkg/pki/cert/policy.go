@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cert
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ExpiryPolicy decides when a certificate is going to expires
+type ExpiryPolicy struct {
+	// RenewBefore renews the certificate if the residual time is below,
+	// it is the lower bound regardless of the lifetime fraction
+	RenewBefore time.Duration
+
+	// LifetimeFraction renews the certificate once this fraction
+	// of the lifetime (notAfter - notBefore) has elapsed, 0 disables it
+	LifetimeFraction float64
+
+	// LifetimeFractions overrides the LifetimeFraction per certificate name
+	LifetimeFractions map[string]float64
+}
+
+// lifetimeFraction returns the lifetime fraction of the certificate name
+func (p ExpiryPolicy) lifetimeFraction(name string) float64 {
+	if fraction, ok := p.LifetimeFractions[name]; ok {
+		return fraction
+	}
+	return p.LifetimeFraction
+}
+
+// CheckExpiry checks if the certificate needs to be renewed at time `now`
+func (p ExpiryPolicy) CheckExpiry(info Info, now time.Time) bool {
+	if info.NotAfter.Before(now) {
+		logrus.Infof("The certificate %s is expiry already", info.Name)
+		return true
+	} else if info.NotAfter.Sub(now) <= p.RenewBefore {
+		logrus.Infof("The certificate %s notAfter is less than user specified expiry time %s", info.Name, p.RenewBefore)
+		return true
+	}
+
+	// the notBefore is unknown if the certificate is not inspected natively
+	fraction := p.lifetimeFraction(info.Name)
+	if fraction > 0 && !info.NotBefore.IsZero() {
+		lifetime := info.NotAfter.Sub(info.NotBefore)
+		elapsed := now.Sub(info.NotBefore)
+		if lifetime > 0 && float64(elapsed) >= fraction*float64(lifetime) {
+			logrus.Infof("The certificate %s elapsed %s of lifetime %s is more than user specified fraction %v", info.Name, elapsed, lifetime, fraction)
+			return true
+		}
+	}
+
+	logrus.Infof("The certificate %s is still valid for %s", info.Name, info.NotAfter.Sub(now))
+	return false
+}
+
+// ValidateLifetimeFraction checks the lifetime fraction is within [0, 1)
+func ValidateLifetimeFraction(fraction float64) error {
+	if fraction < 0 || fraction >= 1 {
+		return fmt.Errorf("invalid lifetime fraction %v, out of range [0, 1)", fraction)
+	}
+	return nil
+}
+
+// ParseLifetimeFractions parses the per certificate name lifetime fractions,
+// e.g. apiserver=0.5
+func ParseLifetimeFractions(m map[string]string) (map[string]float64, error) {
+	fractions := make(map[string]float64, len(m))
+	for name, s := range m {
+		fraction, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lifetime fraction %q of certificate %s: %w", s, name, err)
+		}
+		if err := ValidateLifetimeFraction(fraction); err != nil {
+			return nil, fmt.Errorf("certificate %s: %w", name, err)
+		}
+		fractions[name] = fraction
+	}
+	return fractions, nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cert
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckExpiry(t *testing.T) {
+	now := time.Date(2000, time.January, 02, 03, 04, 05, 06, time.UTC)
+
+	tests := []struct {
+		name   string
+		info   Info
+		policy ExpiryPolicy
+		expect bool
+	}{
+		{
+			name:   "expired certificate",
+			info:   Info{Name: "apiserver", NotAfter: time.Date(1960, time.May, 12, 02, 29, 00, 00, time.UTC)},
+			policy: ExpiryPolicy{RenewBefore: time.Minute},
+			expect: true,
+		},
+		{
+			name:   "going to expire certificate",
+			info:   Info{Name: "apiserver", NotAfter: time.Date(2000, time.January, 02, 03, 05, 05, 06, time.UTC)},
+			policy: ExpiryPolicy{RenewBefore: time.Minute},
+			expect: true,
+		},
+		{
+			name:   "still valid certificate",
+			info:   Info{Name: "apiserver", NotAfter: time.Date(2100, time.May, 12, 02, 29, 00, 00, time.UTC)},
+			policy: ExpiryPolicy{RenewBefore: time.Minute},
+			expect: false,
+		},
+		{
+			name: "lifetime fraction elapsed",
+			info: Info{
+				Name:      "apiserver",
+				NotBefore: now.Add(-time.Hour * 24 * 250),
+				NotAfter:  now.Add(time.Hour * 24 * 115),
+			},
+			policy: ExpiryPolicy{RenewBefore: time.Hour * 24 * 30, LifetimeFraction: 0.66},
+			expect: true,
+		},
+		{
+			name: "lifetime fraction not elapsed",
+			info: Info{
+				Name:      "apiserver",
+				NotBefore: now.Add(-time.Hour * 24 * 200),
+				NotAfter:  now.Add(time.Hour * 24 * 165),
+			},
+			policy: ExpiryPolicy{RenewBefore: time.Hour * 24 * 30, LifetimeFraction: 0.66},
+			expect: false,
+		},
+		{
+			name: "lifetime fraction overridden per certificate",
+			info: Info{
+				Name:      "apiserver",
+				NotBefore: now.Add(-time.Hour * 24 * 200),
+				NotAfter:  now.Add(time.Hour * 24 * 165),
+			},
+			policy: ExpiryPolicy{
+				RenewBefore:       time.Hour * 24 * 30,
+				LifetimeFraction:  0.66,
+				LifetimeFractions: map[string]float64{"apiserver": 0.5},
+			},
+			expect: true,
+		},
+		{
+			name: "short lived certificate below renew before",
+			info: Info{
+				Name:      "kubelet-client-current.pem",
+				NotBefore: now.Add(-time.Hour),
+				NotAfter:  now.Add(time.Hour),
+			},
+			policy: ExpiryPolicy{RenewBefore: time.Hour * 24 * 30, LifetimeFraction: 0.66},
+			expect: true,
+		},
+		{
+			name:   "lifetime fraction without notBefore",
+			info:   Info{Name: "apiserver", NotAfter: now.Add(time.Hour * 24 * 100)},
+			policy: ExpiryPolicy{RenewBefore: time.Hour * 24 * 30, LifetimeFraction: 0.1},
+			expect: false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.CheckExpiry(tt.info, now)
+			if got != tt.expect {
+				t.Errorf("got %t is not equals to expected %t", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestParseLifetimeFractions(t *testing.T) {
+	got, err := ParseLifetimeFractions(map[string]string{"apiserver": "0.5", "admin.conf": " 0.8"})
+	if err != nil {
+		t.Fatalf("expected no error but error reported: %v", err)
+	}
+	if got["apiserver"] != 0.5 || got["admin.conf"] != 0.8 {
+		t.Errorf("got %v is not equals to expected", got)
+	}
+
+	for _, invalid := range []string{"1", "-0.1", "two thirds"} {
+		if _, err := ParseLifetimeFractions(map[string]string{"apiserver": invalid}); err == nil {
+			t.Errorf("expected an error for %q but no error reported", invalid)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rancher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/pki/cert"
+	"github.com/jenting/kucero/pkg/pki/clock"
+)
+
+// certificateDirs are the certificate folders under the data folder,
+// the server certificates only exist on the server nodes
+var certificateDirs []string = []string{"server/tls", "server/tls/etcd", "agent"}
+
+// Rancher is the certificate backend of the k3s and RKE2 nodes,
+// the server certificates are rotated with `<k3s|rke2> certificate rotate`
+// with the binary found in the host PATH,
+// the agent certificates are renewed by the service on restart
+type Rancher struct {
+	distribution string
+	nodeName     string
+	server       bool
+	expiryPolicy cert.ExpiryPolicy
+	clock        clock.Clock
+
+	// service is the systemd unit of the distribution
+	service string
+	// backup is the server TLS folder backup taken by the last rotation
+	backup string
+}
+
+// New returns the k3s or RKE2 instance
+func New(distribution, nodeName string, isServer bool, expiryPolicy cert.ExpiryPolicy) cert.Certificate {
+	return &Rancher{
+		distribution: distribution,
+		nodeName:     nodeName,
+		server:       isServer,
+		expiryPolicy: expiryPolicy,
+		clock:        clock.NewRealClock(),
+		service:      host.KubeletService(distribution, isServer),
+	}
+}
+
+// Inspect reads the server and agent certificates on the host system
+func (r *Rancher) Inspect() ([]cert.Info, error) {
+	return inspectCertificates(filepath.Join(host.Root, host.RancherDataDir(r.distribution)), host.RancherDataDir(r.distribution))
+}
+
+// CheckExpiration checks the node certificates
+// returns the certificates which are going to expires
+func (r *Rancher) CheckExpiration() ([]string, error) {
+	logrus.Infof("Commanding check %s node %s certificate expiration", r.nodeName, r.distribution)
+
+	expiryCertificates := []string{}
+	infos, err := r.Inspect()
+	if err != nil {
+		return expiryCertificates, err
+	}
+
+	now := r.clock.Now()
+	for _, info := range infos {
+		expiry := r.expiryPolicy.CheckExpiry(info, now)
+		if expiry && info.IsCA {
+			logrus.Warnf("The %s node CA %s expires at %v, it is not rotated by kucero", r.nodeName, info.Name, info.NotAfter)
+			continue
+		}
+		if expiry {
+			expiryCertificates = append(expiryCertificates, info.Name)
+		}
+	}
+
+	return expiryCertificates, nil
+}
+
+// Rotate backs up the server TLS folder, stops the service,
+// rotates the server certificates and starts the service,
+// the agent certificates are renewed by the service on start
+func (r *Rancher) Rotate(expiryCertificates []string) error {
+	r.backup = ""
+	if !r.server {
+		return host.RestartService(r.nodeName, r.service)
+	}
+
+	binary, err := host.RancherBinary(r.distribution)
+	if err != nil {
+		return err
+	}
+
+	tlsDir := filepath.Join(host.RancherDataDir(r.distribution), "server/tls")
+	backup := tlsDir + "-" + time.Now().Format("20060102030405") + ".bak"
+	if err := r.run("/usr/bin/cp", "-a", tlsDir, backup); err != nil {
+		return err
+	}
+	r.backup = backup
+
+	if err := host.StopService(r.nodeName, r.service); err != nil {
+		return err
+	}
+	logrus.Infof("Commanding rotate %s node %s certificates %v", r.nodeName, r.distribution, expiryCertificates)
+	rotateErr := r.run(binary, "certificate", "rotate")
+	if err := host.StartService(r.nodeName, r.service); err != nil {
+		return err
+	}
+
+	return rotateErr
+}
+
+// Verify waits for the service to be active
+func (r *Rancher) Verify(timeout time.Duration) error {
+	return host.WaitForServiceActive(r.nodeName, r.service, timeout)
+}
+
+// Rollback restores the server TLS folder backed up by the last rotation
+// and restarts the service
+func (r *Rancher) Rollback() error {
+	if r.backup == "" {
+		return host.RestartService(r.nodeName, r.service)
+	}
+	logrus.Infof("Commanding rollback %s node %s certificates from %s", r.nodeName, r.distribution, r.backup)
+
+	if err := host.StopService(r.nodeName, r.service); err != nil {
+		return err
+	}
+	tlsDir := filepath.Join(host.RancherDataDir(r.distribution), "server/tls")
+	var errs error
+	if err := r.run("/usr/bin/rm", "-rf", tlsDir); err != nil {
+		errs = errors.Join(errs, err)
+	} else if err := r.run("/usr/bin/cp", "-a", r.backup, tlsDir); err != nil {
+		errs = errors.Join(errs, err)
+	}
+	if err := host.StartService(r.nodeName, r.service); err != nil {
+		errs = errors.Join(errs, err)
+	}
+
+	return errs
+}
+
+// Restart starts the service in case the rotation
+// was interrupted while it was stopped
+func (r *Rancher) Restart(certificateNames []string) error {
+	return host.StartService(r.nodeName, r.service)
+}
+
+// Backups returns the server TLS folder backup taken by the last rotation
+func (r *Rancher) Backups() []string {
+	if r.backup == "" {
+		return nil
+	}
+	return []string{r.backup}
+}
+
+// run executes the command in the host mount space
+func (r *Rancher) run(name string, arg ...string) error {
+	// Relies on hostPID:true and privileged:true to enter host mount space
+	cmd := host.NewCommand("/usr/bin/nsenter", append([]string{"-m/proc/1/ns/mnt", name}, arg...)...)
+	err := cmd.Run()
+	if err != nil {
+		logrus.Errorf("Error invoking %s on %s node: %v", cmd.Args, r.nodeName, err)
+	}
+	return err
+}
+
+// inspectCertificates reads the certificates and CAs in the certificate folders
+// under `dataDir`, the certificates are named by their path relative to
+// the data folder, e.g. client-admin, etcd-server-client or agent-client-kubelet,
+// `hostDataDir` is the data folder path reported on the host system
+// returns the certificates information sorted by certificate name
+func inspectCertificates(dataDir, hostDataDir string) ([]cert.Info, error) {
+	infos := []cert.Info{}
+	for _, dir := range certificateDirs {
+		paths, _ := filepath.Glob(filepath.Join(dataDir, dir, "*.crt"))
+		for _, path := range paths {
+			c, err := cert.ParseCertificateFile(path)
+			if err != nil {
+				return infos, fmt.Errorf("failed to inspect certificate %s: %w", path, err)
+			}
+			rel, _ := filepath.Rel(dataDir, path)
+			name := strings.TrimSuffix(strings.TrimPrefix(rel, "server/tls/"), ".crt")
+			name = strings.ReplaceAll(name, string(os.PathSeparator), "-")
+			infos = append(infos, cert.NewInfo(name, filepath.Join(hostDataDir, rel), c))
+		}
+	}
+	if len(infos) == 0 {
+		return infos, errors.New("no certificates found")
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	return infos, nil
+}
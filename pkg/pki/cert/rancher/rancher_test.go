@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rancher
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed PEM encoded certificate
+func writeTestCertificate(t *testing.T, path string, isCA bool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: filepath.Base(path)},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_inspectCertificates(t *testing.T) {
+	dataDir := t.TempDir()
+	writeTestCertificate(t, filepath.Join(dataDir, "server/tls/server-ca.crt"), true)
+	writeTestCertificate(t, filepath.Join(dataDir, "server/tls/client-admin.crt"), false)
+	writeTestCertificate(t, filepath.Join(dataDir, "server/tls/serving-kube-apiserver.crt"), false)
+	writeTestCertificate(t, filepath.Join(dataDir, "server/tls/etcd/peer-ca.crt"), true)
+	writeTestCertificate(t, filepath.Join(dataDir, "server/tls/etcd/server-client.crt"), false)
+	writeTestCertificate(t, filepath.Join(dataDir, "server/tls/temporary-certs/apiserver-loopback-client__.crt"), false)
+	writeTestCertificate(t, filepath.Join(dataDir, "agent/client-kubelet.crt"), false)
+	writeTestCertificate(t, filepath.Join(dataDir, "agent/server-ca.crt"), true)
+
+	infos, err := inspectCertificates(dataDir, "/var/lib/rancher/k3s")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := map[string]string{
+		"agent-server-ca":        "/var/lib/rancher/k3s/agent/server-ca.crt",
+		"server-ca":              "/var/lib/rancher/k3s/server/tls/server-ca.crt",
+		"etcd-peer-ca":           "/var/lib/rancher/k3s/server/tls/etcd/peer-ca.crt",
+		"agent-client-kubelet":   "/var/lib/rancher/k3s/agent/client-kubelet.crt",
+		"client-admin":           "/var/lib/rancher/k3s/server/tls/client-admin.crt",
+		"etcd-server-client":     "/var/lib/rancher/k3s/server/tls/etcd/server-client.crt",
+		"serving-kube-apiserver": "/var/lib/rancher/k3s/server/tls/serving-kube-apiserver.crt",
+	}
+	if len(infos) != len(expected) {
+		t.Fatalf("expected %d certificates, got %v", len(expected), infos)
+	}
+	for _, info := range infos {
+		if expected[info.Name] != info.Path {
+			t.Errorf("expected certificate %s path %s, got %s", info.Name, expected[info.Name], info.Path)
+		}
+	}
+}
+
+func Test_inspectCertificatesNotFound(t *testing.T) {
+	if _, err := inspectCertificates(t.TempDir(), "/var/lib/rancher/rke2"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -21,6 +21,10 @@ type Config interface {
 	// returns the configuration and it's update config callback function
 	CheckConfig() ([]string, error)
 
+	// DiffConfig returns the unified diff of each configuration
+	// to be updated without updating it
+	DiffConfig([]string) (map[string]string, error)
+
 	// UpdateConfig updates the configuration by
 	// passing the configuration and it's update config callback function
 	UpdateConfig([]string) error
@@ -21,14 +21,22 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 
 	"k8s.io/client-go/tools/clientcmd"
 	"sigs.k8s.io/yaml"
+
+	"github.com/pmezard/go-difflib/difflib"
 )
 
 type action struct {
 	check  func(*Kubelet, string) (bool, error)
 	update func(*Kubelet, string, string) error
+	// diff returns the diff of the update if set,
+	// otherwise the update is applied to a temporary copy
+	diff func(*Kubelet, string) (string, error)
+	// verify waits for kubelet to apply the update once restarted if set
+	verify func(*Kubelet, string) error
 }
 
 var configs map[string]action = map[string]action{
@@ -48,6 +56,31 @@ var configs map[string]action = map[string]action{
 			return k.updateVarLibKubeletConfigYaml(oldFilepath, newFilepath)
 		},
 	},
+	servingCertificatePath: {
+		check: func(k *Kubelet, filepath string) (bool, error) {
+			return k.checkKubeletServerCertificate(filepath)
+		},
+		update: func(k *Kubelet, oldFilepath, newFilepath string) error {
+			return k.updateKubeletServerCertificate(oldFilepath, newFilepath)
+		},
+		diff: func(k *Kubelet, filepath string) (string, error) {
+			return k.diffKubeletServerCertificate(filepath)
+		},
+		verify: func(k *Kubelet, filepath string) error {
+			return k.verifyKubeletServerCertificate(filepath)
+		},
+	},
+}
+
+// configPaths returns the config paths in sorted order
+// so the checks run and report in the same order
+func configPaths() []string {
+	paths := make([]string, 0, len(configs))
+	for path := range configs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
 }
 
 // checkEtcKubernetesKubeletConf checks /etc/kubernetes/kubelet.conf need to be update
@@ -164,3 +197,39 @@ func (k *Kubelet) updateVarLibKubeletConfigYaml(oldFilepath, newFilepath string)
 	}
 	return ioutil.WriteFile(newFilepath, kubeletConfig, f.Mode())
 }
+
+// diffConfig updates a temporary copy of the configuration
+// returns the unified diff between the configuration and the updated copy
+func diffConfig(k *Kubelet, filepath string, action action) (string, error) {
+	if action.diff != nil {
+		return action.diff(k, filepath)
+	}
+
+	file, err := ioutil.TempFile("", "kucero-*")
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if err := action.update(k, filepath, file.Name()); err != nil {
+		return "", err
+	}
+
+	oldConfig, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return "", err
+	}
+	newConfig, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(oldConfig)),
+		B:        difflib.SplitLines(string(newConfig)),
+		FromFile: filepath,
+		ToFile:   filepath,
+		Context:  3,
+	})
+}
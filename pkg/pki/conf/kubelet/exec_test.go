@@ -17,10 +17,13 @@ limitations under the License.
 package kubelet
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -183,3 +186,62 @@ func TestVarLibKubeletConfigYaml(t *testing.T) {
 		})
 	}
 }
+
+func TestDiffConfig(t *testing.T) {
+	k := &Kubelet{
+		nodeName:                        "node",
+		enableKubeletClientCertRotation: true,
+		enableKubeletServerCertRotation: true,
+	}
+
+	filepath := "tests/client-kubelet.yaml"
+	before, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := diffConfig(k, filepath, configs["/var/lib/kubelet/config.yaml"])
+	if err != nil {
+		t.Errorf("expected no error but error reported: %v\n", err)
+	}
+	if !strings.Contains(diff, "+serverTLSBootstrap: true") {
+		t.Errorf("expected serverTLSBootstrap to be added but got diff:\n%s", diff)
+	}
+
+	after, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("expected %s not to be updated", filepath)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	defaultConfigs := configs
+	defer func() { configs = defaultConfigs }()
+
+	failing := func(k *Kubelet, filepath string) (bool, error) {
+		return false, fmt.Errorf("failed to check %s", filepath)
+	}
+	toUpdate := func(k *Kubelet, filepath string) (bool, error) {
+		return true, nil
+	}
+	configs = map[string]action{
+		"/d.yaml": {check: toUpdate},
+		"/c.yaml": {check: failing},
+		"/b.yaml": {check: toUpdate},
+		"/a.yaml": {check: failing},
+	}
+
+	k := &Kubelet{nodeName: "node"}
+	for i := 0; i < 10; i++ {
+		configsToBeUpdate, err := k.CheckConfig()
+		if !reflect.DeepEqual(configsToBeUpdate, []string{"/b.yaml", "/d.yaml"}) {
+			t.Errorf("expected the configs to be updated in sorted order, got %v", configsToBeUpdate)
+		}
+		if err == nil || err.Error() != "failed to check /a.yaml\nfailed to check /c.yaml" {
+			t.Errorf("expected every check error in sorted order, got %v", err)
+		}
+	}
+}
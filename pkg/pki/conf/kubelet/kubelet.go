@@ -17,9 +17,12 @@ limitations under the License.
 package kubelet
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
 
 	"github.com/jenting/kucero/pkg/host"
 	"github.com/jenting/kucero/pkg/pki/conf"
@@ -27,14 +30,18 @@ import (
 
 type Kubelet struct {
 	nodeName                        string
+	client                          kubernetes.Interface
+	verifyTimeout                   time.Duration
 	enableKubeletClientCertRotation bool
 	enableKubeletServerCertRotation bool
 }
 
 // New returns the kubelet instance
-func New(nodeName string, enableKubeletClientCertRotation, enableKubeletServerCertRotation bool) conf.Config {
+func New(nodeName string, client kubernetes.Interface, verifyTimeout time.Duration, enableKubeletClientCertRotation, enableKubeletServerCertRotation bool) conf.Config {
 	return &Kubelet{
 		nodeName:                        nodeName,
+		client:                          client,
+		verifyTimeout:                   verifyTimeout,
 		enableKubeletClientCertRotation: enableKubeletClientCertRotation,
 		enableKubeletServerCertRotation: enableKubeletServerCertRotation,
 	}
@@ -45,10 +52,10 @@ func (k *Kubelet) CheckConfig() ([]string, error) {
 
 	var errs error
 	var configsToBeUpdate []string
-	for filepath, action := range configs {
-		toUpdate, err := action.check(k, filepath)
+	for _, filepath := range configPaths() {
+		toUpdate, err := configs[filepath].check(k, filepath)
 		if err != nil {
-			errs = fmt.Errorf("%w; ", err)
+			errs = errors.Join(errs, err)
 			continue
 		}
 		if toUpdate {
@@ -59,6 +66,25 @@ func (k *Kubelet) CheckConfig() ([]string, error) {
 	return configsToBeUpdate, errs
 }
 
+func (k *Kubelet) DiffConfig(configsToBeUpdate []string) (map[string]string, error) {
+	var errs error
+	diffs := map[string]string{}
+	for _, configToBeUpdate := range configsToBeUpdate {
+		action, ok := configs[configToBeUpdate]
+		if !ok {
+			return diffs, fmt.Errorf("map key %s does not exist", configToBeUpdate)
+		}
+		diff, err := diffConfig(k, configToBeUpdate, action)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		diffs[configToBeUpdate] = diff
+	}
+
+	return diffs, errs
+}
+
 func (k *Kubelet) UpdateConfig(configsToBeUpdate []string) error {
 	var errs error
 	for _, configToBeUpdate := range configsToBeUpdate {
@@ -70,7 +96,7 @@ func (k *Kubelet) UpdateConfig(configsToBeUpdate []string) error {
 		}
 		err := action.update(k, configToBeUpdate, configToBeUpdate)
 		if err != nil {
-			errs = fmt.Errorf("%w; ", err)
+			errs = errors.Join(errs, err)
 			continue
 		}
 	}
@@ -79,7 +105,15 @@ func (k *Kubelet) UpdateConfig(configsToBeUpdate []string) error {
 	}
 
 	if err := host.RestartKubelet(k.nodeName); err != nil {
-		errs = fmt.Errorf("%w; ", err)
+		return err
+	}
+
+	for _, configToBeUpdate := range configsToBeUpdate {
+		if action := configs[configToBeUpdate]; action.verify != nil {
+			if err := action.verify(k, configToBeUpdate); err != nil {
+				errs = errors.Join(errs, err)
+			}
+		}
 	}
 
 	return errs
@@ -0,0 +1,176 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubelet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/jenting/kucero/pkg/host"
+	"github.com/jenting/kucero/pkg/pki/cert"
+)
+
+const (
+	// servingCertificatePath is the current kubelet serving certificate and key file
+	// kubelet requests with a CSR if serverTLSBootstrap is enabled
+	servingCertificatePath = "/var/lib/kubelet/pki/kubelet-server-current.pem"
+
+	servingCertificatePollInterval = 5 * time.Second
+)
+
+// checkKubeletServerCertificate checks the kubelet serving certificate needs to be reissued
+// if its SANs differ from the node status addresses, e.g. the node IP changed
+func (k *Kubelet) checkKubeletServerCertificate(filepath string) (bool, error) {
+	if !k.enableKubeletServerCertRotation || k.client == nil {
+		return false, nil
+	}
+
+	current, desired, err := k.servingCertificateSANs(filepath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if strings.Join(current, ",") != strings.Join(desired, ",") {
+		logrus.Infof("The %s node kubelet serving certificate SANs %v differ from the node addresses %v", k.nodeName, current, desired)
+		return true, nil
+	}
+	return false, nil
+}
+
+// updateKubeletServerCertificate removes the kubelet serving certificate
+// so that kubelet requests a new one with the node addresses once restarted
+func (k *Kubelet) updateKubeletServerCertificate(oldFilepath, newFilepath string) error {
+	// Relies on hostPID:true and privileged:true to enter host mount space
+	cmd := host.NewCommand("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/usr/bin/rm", "-f", oldFilepath)
+	err := cmd.Run()
+	if err != nil {
+		logrus.Errorf("Error invoking %s: %v", cmd.Args, err)
+	}
+
+	return err
+}
+
+// diffKubeletServerCertificate returns the unified diff between
+// the kubelet serving certificate SANs and the node addresses
+func (k *Kubelet) diffKubeletServerCertificate(filepath string) (string, error) {
+	current, desired, err := k.servingCertificateSANs(filepath)
+	if err != nil {
+		return "", err
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(strings.Join(current, "\n") + "\n"),
+		B:        difflib.SplitLines(strings.Join(desired, "\n") + "\n"),
+		FromFile: filepath,
+		ToFile:   filepath,
+		Context:  3,
+	})
+}
+
+// verifyKubeletServerCertificate waits up to the verify timeout for kubelet
+// to get the serving certificate with the node addresses
+func (k *Kubelet) verifyKubeletServerCertificate(filepath string) error {
+	logrus.Infof("Waiting for %s node kubelet to get its serving certificate", k.nodeName)
+
+	err := wait.PollUntilContextTimeout(context.TODO(), servingCertificatePollInterval, k.verifyTimeout, true, func(ctx context.Context) (bool, error) {
+		if _, err := os.Stat(filepath); err != nil {
+			return false, nil
+		}
+		toUpdate, err := k.checkKubeletServerCertificate(filepath)
+		if err != nil {
+			logrus.Debugf("Error reading kubelet serving certificate: %v", err)
+			return false, nil
+		}
+		return !toUpdate, nil
+	})
+	if err != nil {
+		return fmt.Errorf("kubelet has no serving certificate with the node addresses on %s node after %s: %w", k.nodeName, k.verifyTimeout, err)
+	}
+	return nil
+}
+
+// servingCertificateSANs returns the SANs of the kubelet serving certificate
+// and the SANs kubelet requests for the node status addresses,
+// as `DNS:<name>` or `IP:<address>` sorted
+func (k *Kubelet) servingCertificateSANs(filepath string) ([]string, []string, error) {
+	c, err := cert.ParseCertificateFile(filepath)
+	if err != nil {
+		return nil, nil, err
+	}
+	current := []string{}
+	for _, dnsName := range c.DNSNames {
+		current = append(current, "DNS:"+dnsName)
+	}
+	for _, ip := range c.IPAddresses {
+		current = append(current, "IP:"+ip.String())
+	}
+
+	node, err := k.client.CoreV1().Nodes().Get(context.TODO(), k.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	desired := addressSANs(node.Status.Addresses)
+
+	return sortedUnique(current), sortedUnique(desired), nil
+}
+
+// addressSANs returns the SANs kubelet requests its serving certificate with,
+// the host names as DNS names and the node IPs as IP addresses
+func addressSANs(addresses []corev1.NodeAddress) []string {
+	sans := []string{}
+	for _, address := range addresses {
+		if address.Address == "" {
+			continue
+		}
+		switch address.Type {
+		case corev1.NodeHostName, corev1.NodeInternalDNS, corev1.NodeExternalDNS:
+			sans = append(sans, "DNS:"+address.Address)
+		case corev1.NodeInternalIP, corev1.NodeExternalIP:
+			if ip := net.ParseIP(address.Address); ip != nil {
+				sans = append(sans, "IP:"+ip.String())
+			}
+		}
+	}
+	return sans
+}
+
+// sortedUnique returns the sorted unique strings
+func sortedUnique(ss []string) []string {
+	sort.Strings(ss)
+	unique := []string{}
+	for i, s := range ss {
+		if i > 0 && s == ss[i-1] {
+			continue
+		}
+		unique = append(unique, s)
+	}
+	return unique
+}
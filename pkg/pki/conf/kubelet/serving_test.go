@@ -0,0 +1,153 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubelet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKubeletServerCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "system:node:worker"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"worker"},
+		IPAddresses:  []net.IP{net.ParseIP("192.168.0.10")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "kubelet-server-current.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                            string
+		addresses                       []corev1.NodeAddress
+		enableKubeletServerCertRotation bool
+		path                            string
+		expect                          bool
+		expectDiff                      []string
+	}{
+		{
+			name: "node addresses unchanged",
+			addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "192.168.0.10"},
+				{Type: corev1.NodeHostName, Address: "worker"},
+			},
+			enableKubeletServerCertRotation: true,
+			path:                            path,
+		},
+		{
+			name: "node IP changed",
+			addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "192.168.0.20"},
+				{Type: corev1.NodeHostName, Address: "worker"},
+			},
+			enableKubeletServerCertRotation: true,
+			path:                            path,
+			expect:                          true,
+			expectDiff:                      []string{"-IP:192.168.0.10", "+IP:192.168.0.20"},
+		},
+		{
+			name: "dual-stack enabled",
+			addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "192.168.0.10"},
+				{Type: corev1.NodeInternalIP, Address: "fd00::10"},
+				{Type: corev1.NodeHostName, Address: "worker"},
+			},
+			enableKubeletServerCertRotation: true,
+			path:                            path,
+			expect:                          true,
+			expectDiff:                      []string{"+IP:fd00::10"},
+		},
+		{
+			name: "serving certificate rotation disabled",
+			addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "192.168.0.20"},
+			},
+			path: path,
+		},
+		{
+			name: "no serving certificate",
+			addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "192.168.0.20"},
+			},
+			enableKubeletServerCertRotation: true,
+			path:                            filepath.Join(t.TempDir(), "kubelet-server-current.pem"),
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			k := &Kubelet{
+				nodeName: "worker",
+				client: fake.NewSimpleClientset(&corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: "worker"},
+					Status:     corev1.NodeStatus{Addresses: tt.addresses},
+				}),
+				enableKubeletServerCertRotation: tt.enableKubeletServerCertRotation,
+			}
+			got, err := k.checkKubeletServerCertificate(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expect {
+				t.Errorf("expected %t, got %t", tt.expect, got)
+			}
+			if !got {
+				return
+			}
+
+			diff, err := diffConfig(k, tt.path, configs[servingCertificatePath])
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range tt.expectDiff {
+				if !strings.Contains(diff, line+"\n") {
+					t.Errorf("expected %q in diff %s", line, diff)
+				}
+			}
+		})
+	}
+}
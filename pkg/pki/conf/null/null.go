@@ -17,25 +17,29 @@ limitations under the License.
 package null
 
 import (
-	"time"
-
-	"github.com/jenting/kucero/pkg/pki/cert"
+	"github.com/jenting/kucero/pkg/pki/conf"
 )
 
 type Null struct {
 }
 
-// New returns the kubeadm instance
-func New(nodeName string, expiryTimeToRotate time.Duration) cert.Certificate {
+// New returns the null configuration instance
+// for the nodes whose kubelet configuration is not managed by kucero
+func New(nodeName string) conf.Config {
 	return &Null{}
 }
 
-// CheckExpiration returns empty slice string array and nil
-func (n *Null) CheckExpiration() ([]string, error) {
+// CheckConfig returns empty slice string array and nil
+func (n *Null) CheckConfig() ([]string, error) {
 	return []string{}, nil
 }
 
-// Rotate returns nil
-func (n *Null) Rotate(expiryCertificates []string) error {
+// DiffConfig returns empty map and nil
+func (n *Null) DiffConfig(configsToBeUpdate []string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+// UpdateConfig returns nil
+func (n *Null) UpdateConfig(configsToBeUpdate []string) error {
 	return nil
 }
@@ -19,29 +19,83 @@ package node
 import (
 	"time"
 
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jenting/kucero/pkg/host"
 	"github.com/jenting/kucero/pkg/pki/cert"
 	"github.com/jenting/kucero/pkg/pki/cert/kubeadm"
-	"github.com/jenting/kucero/pkg/pki/cert/null"
+	certkubelet "github.com/jenting/kucero/pkg/pki/cert/kubelet"
+	"github.com/jenting/kucero/pkg/pki/cert/rancher"
 	"github.com/jenting/kucero/pkg/pki/conf"
 	"github.com/jenting/kucero/pkg/pki/conf/kubelet"
+	confnull "github.com/jenting/kucero/pkg/pki/conf/null"
 )
 
 type Node struct {
 	conf.Config      // configureation interface
 	cert.Certificate // certificate interface
+
+	// Distribution is the Kubernetes distribution of the node
+	Distribution string
+	// Service is the systemd unit running kubelet on the node
+	Service string
 }
 
-// New checks if it's a control plane node or worker node
+// New checks the distribution and if it's a control plane node or worker node
 // then returns the corresponding node interface
-func New(isControlPlane bool, name string, expiryTimeToRotate time.Duration, enableKubeletClientCertRotation, enableKubeletServerCertRotation bool) *Node {
-	if isControlPlane {
+func New(distribution string, isControlPlane bool, name string, client kubernetes.Interface, expiryPolicy cert.ExpiryPolicy, staticPodRestartTimeout, verifyTimeout time.Duration, renewal kubeadm.Renewal, kubeadmConfigPath string, enableKubeletClientCertRotation, enableKubeletServerCertRotation bool) *Node {
+	service := host.KubeletService(distribution, isControlPlane)
+
+	switch {
+	case distribution == host.DistributionK3s || distribution == host.DistributionRKE2:
+		// the kubelet configuration is managed by the distribution
+		return &Node{
+			Config:       confnull.New(name),
+			Certificate:  rancher.New(distribution, name, isControlPlane, expiryPolicy),
+			Distribution: distribution,
+			Service:      service,
+		}
+	case isControlPlane:
+		return &Node{
+			Config:       kubelet.New(name, client, verifyTimeout, enableKubeletClientCertRotation, enableKubeletServerCertRotation),
+			Certificate:  kubeadm.New(name, client, expiryPolicy, staticPodRestartTimeout, renewal, kubeadmConfigPath),
+			Distribution: distribution,
+			Service:      service,
+		}
+	default:
 		return &Node{
-			Config:      kubelet.New(name, enableKubeletClientCertRotation, enableKubeletServerCertRotation),
-			Certificate: kubeadm.New(name, expiryTimeToRotate),
+			Config:       kubelet.New(name, client, verifyTimeout, enableKubeletClientCertRotation, enableKubeletServerCertRotation),
+			Certificate:  certkubelet.New(name, client, expiryPolicy),
+			Distribution: distribution,
+			Service:      service,
 		}
 	}
-	return &Node{
-		Config:      kubelet.New(name, enableKubeletClientCertRotation, enableKubeletServerCertRotation),
-		Certificate: null.New(name, expiryTimeToRotate),
+}
+
+// CheckDrift returns the certificates whose identity drifted,
+// none if the certificate backend does not detect drift
+func (n *Node) CheckDrift() ([]cert.Drift, error) {
+	if checker, ok := n.Certificate.(cert.DriftChecker); ok {
+		return checker.CheckDrift()
+	}
+	return nil, nil
+}
+
+// SignedCertificates splits the certificates to be renewed
+// into the certificates ready to be rotated and the certificates
+// waiting to be signed by the external CA, with the rejected requests
+func (n *Node) SignedCertificates(certificates []string) ([]string, []string, []cert.Rejection) {
+	if signer, ok := n.Certificate.(cert.ExternalSigner); ok {
+		return signer.SignedCertificates(certificates)
+	}
+	return certificates, nil, nil
+}
+
+// RequestCertificates requests the external CA to sign the certificates,
+// nothing is requested if the certificate backend has no external CA
+func (n *Node) RequestCertificates(certificates []string) error {
+	if signer, ok := n.Certificate.(cert.ExternalSigner); ok {
+		return signer.RequestCertificates(certificates)
 	}
+	return nil
 }
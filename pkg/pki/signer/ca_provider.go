@@ -18,6 +18,7 @@ package signer
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"fmt"
 	"sync/atomic"
@@ -59,8 +60,10 @@ func (p *caProvider) setCA() error {
 	if err != nil {
 		return fmt.Errorf("error reading CA cert file %q: %v", p.caLoader.Name(), err)
 	}
-	if len(certs) != 1 {
-		return fmt.Errorf("error reading CA cert file %q: expected 1 certificate, found %d", p.caLoader.Name(), len(certs))
+	// the CA rotation bundles the old and the new CA certificates,
+	// the first one matches the key
+	if len(certs) == 0 {
+		return fmt.Errorf("error reading CA cert file %q: no certificate found", p.caLoader.Name())
 	}
 
 	key, err := keyutil.ParsePrivateKeyPEM(keyPEM)
@@ -86,8 +89,12 @@ func (p *caProvider) setCA() error {
 }
 
 // currentCA provides the curent value of the CA.
-// It always check for a stale value.  This is cheap because it's all an in memory cache of small slices.
+// It always reloads the files and check for a stale value.  This is cheap because the files are small.
 func (p *caProvider) currentCA() (*authority.CertificateAuthority, error) {
+	// reloads the files replaced by the CA rotation,
+	// keeps the current content if they cannot be loaded, e.g. half written
+	_ = p.caLoader.RunOnce(context.TODO())
+
 	certPEM, keyPEM := p.caLoader.CurrentCertKeyContent()
 	currCA := p.caValue.Load().(*authority.CertificateAuthority)
 	if bytes.Equal(currCA.RawCert, certPEM) && bytes.Equal(currCA.RawKey, keyPEM) {
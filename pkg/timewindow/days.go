@@ -0,0 +1,130 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+Copyright The Kured Authors.
+
+Adapted from the kured timewindow package
+https://github.com/kubereboot/kured/tree/main/pkg/timewindow
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timewindow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EveryDay contains all days of the week
+var EveryDay = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+var dayNames map[string]time.Weekday = map[string]time.Weekday{
+	"su":        time.Sunday,
+	"sun":       time.Sunday,
+	"sunday":    time.Sunday,
+	"mo":        time.Monday,
+	"mon":       time.Monday,
+	"monday":    time.Monday,
+	"tu":        time.Tuesday,
+	"tue":       time.Tuesday,
+	"tuesday":   time.Tuesday,
+	"we":        time.Wednesday,
+	"wed":       time.Wednesday,
+	"wednesday": time.Wednesday,
+	"th":        time.Thursday,
+	"thu":       time.Thursday,
+	"thursday":  time.Thursday,
+	"fr":        time.Friday,
+	"fri":       time.Friday,
+	"friday":    time.Friday,
+	"sa":        time.Saturday,
+	"sat":       time.Saturday,
+	"saturday":  time.Saturday,
+}
+
+// weekdays is a bit set of time.Weekday
+type weekdays uint8
+
+// parseWeekdays parses the days, e.g. ["mon-fri", "sun"]
+func parseWeekdays(days []string) (weekdays, error) {
+	var w weekdays
+	for _, day := range days {
+		day = strings.TrimSpace(day)
+		if day == "" {
+			continue
+		}
+
+		if from, to, ok := strings.Cut(day, "-"); ok {
+			f, err := parseWeekday(from)
+			if err != nil {
+				return 0, err
+			}
+			t, err := parseWeekday(to)
+			if err != nil {
+				return 0, err
+			}
+			for d := f; ; d = (d + 1) % 7 {
+				w |= 1 << d
+				if d == t {
+					break
+				}
+			}
+			continue
+		}
+
+		d, err := parseWeekday(day)
+		if err != nil {
+			return 0, err
+		}
+		w |= 1 << d
+	}
+	if w == 0 {
+		return 0, fmt.Errorf("no week day specified")
+	}
+
+	return w, nil
+}
+
+func parseWeekday(day string) (time.Weekday, error) {
+	if n, err := strconv.Atoi(day); err == nil {
+		if n < 0 || n > 6 {
+			return time.Sunday, fmt.Errorf("invalid week day %q, out of range 0-6", day)
+		}
+		return time.Weekday(n), nil
+	}
+
+	d, ok := dayNames[strings.ToLower(day)]
+	if !ok {
+		return time.Sunday, fmt.Errorf("invalid week day %q", day)
+	}
+	return d, nil
+}
+
+func (w weekdays) contains(d time.Weekday) bool {
+	return w&(1<<d) != 0
+}
+
+// String returns the week days, e.g. "---MonTueWedThuFri---"
+func (w weekdays) String() string {
+	var b strings.Builder
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if w.contains(d) {
+			b.WriteString(d.String()[:3])
+		} else {
+			b.WriteString("---")
+		}
+	}
+	return b.String()
+}
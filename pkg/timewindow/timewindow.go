@@ -0,0 +1,97 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+Copyright The Kured Authors.
+
+Adapted from the kured timewindow package
+https://github.com/kubereboot/kured/tree/main/pkg/timewindow
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timewindow
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeWindow is a maintenance window schedule of week days and time of day
+type TimeWindow struct {
+	days      weekdays
+	location  *time.Location
+	startTime time.Time
+	endTime   time.Time
+}
+
+// New returns the time window of the given days, start time, end time and time zone
+// the days accept names (mon, monday), numbers (0 is sunday) and ranges (mon-fri)
+// the end time before the start time wraps the window over midnight
+func New(days []string, startTime, endTime, timeZone string) (*TimeWindow, error) {
+	tw := &TimeWindow{}
+
+	var err error
+	if tw.days, err = parseWeekdays(days); err != nil {
+		return nil, err
+	}
+	if tw.location, err = time.LoadLocation(timeZone); err != nil {
+		return nil, err
+	}
+	if tw.startTime, err = parseTime(startTime, tw.location); err != nil {
+		return nil, err
+	}
+	if tw.endTime, err = parseTime(endTime, tw.location); err != nil {
+		return nil, err
+	}
+
+	return tw, nil
+}
+
+// Contains checks if the time `t` is inside the time window
+func (tw *TimeWindow) Contains(t time.Time) bool {
+	lt := t.In(tw.location)
+
+	start := time.Date(lt.Year(), lt.Month(), lt.Day(), tw.startTime.Hour(), tw.startTime.Minute(), tw.startTime.Second(), 0, tw.location)
+	end := time.Date(lt.Year(), lt.Month(), lt.Day(), tw.endTime.Hour(), tw.endTime.Minute(), tw.endTime.Second(), 1e9-1, tw.location)
+
+	// the window wraps over midnight, e.g. 22:00-06:00
+	// the window belongs to the day it starts on
+	if tw.startTime.After(tw.endTime) {
+		if lt.Before(end) {
+			start = start.AddDate(0, 0, -1)
+		} else {
+			end = end.AddDate(0, 0, 1)
+		}
+	}
+
+	if !tw.days.contains(start.Weekday()) {
+		return false
+	}
+	return !lt.Before(start) && !lt.After(end)
+}
+
+// String returns the time window in a human readable format
+func (tw *TimeWindow) String() string {
+	return fmt.Sprintf("%s between %02d:%02d and %02d:%02d %s",
+		tw.days, tw.startTime.Hour(), tw.startTime.Minute(), tw.endTime.Hour(), tw.endTime.Minute(), tw.location)
+}
+
+// parseTime parses the time of day with the supported layouts
+func parseTime(s string, location *time.Location) (time.Time, error) {
+	layouts := []string{"15:04", "15:04:05", "03:04pm", "15", "03pm", "3pm"}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time of day %q", s)
+}
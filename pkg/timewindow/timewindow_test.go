@@ -0,0 +1,166 @@
+/*
+Copyright (c) 2020 SUSE LLC.
+Copyright The Kured Authors.
+
+Adapted from the kured timewindow package
+https://github.com/kubereboot/kured/tree/main/pkg/timewindow
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timewindow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeWindow(t *testing.T) {
+	tests := []struct {
+		name      string
+		days      []string
+		startTime string
+		endTime   string
+		timeZone  string
+		input     time.Time
+		expect    bool
+	}{
+		{
+			name:      "every day, whole day",
+			days:      EveryDay,
+			startTime: "00:00",
+			endTime:   "23:59:59",
+			timeZone:  "UTC",
+			input:     time.Date(2020, time.May, 13, 12, 00, 00, 00, time.UTC),
+			expect:    true,
+		},
+		{
+			name:      "working days, inside business hours",
+			days:      []string{"mon-fri"},
+			startTime: "09:00",
+			endTime:   "17:00",
+			timeZone:  "UTC",
+			input:     time.Date(2020, time.May, 13, 12, 00, 00, 00, time.UTC), // Wednesday
+			expect:    true,
+		},
+		{
+			name:      "working days, weekend",
+			days:      []string{"mon-fri"},
+			startTime: "09:00",
+			endTime:   "17:00",
+			timeZone:  "UTC",
+			input:     time.Date(2020, time.May, 16, 12, 00, 00, 00, time.UTC), // Saturday
+			expect:    false,
+		},
+		{
+			name:      "overnight window, after start",
+			days:      []string{"fri"},
+			startTime: "22:00",
+			endTime:   "06:00",
+			timeZone:  "UTC",
+			input:     time.Date(2020, time.May, 15, 23, 00, 00, 00, time.UTC), // Friday
+			expect:    true,
+		},
+		{
+			name:      "overnight window, before end on the next day",
+			days:      []string{"fri"},
+			startTime: "22:00",
+			endTime:   "06:00",
+			timeZone:  "UTC",
+			input:     time.Date(2020, time.May, 16, 05, 00, 00, 00, time.UTC), // Saturday
+			expect:    true,
+		},
+		{
+			name:      "overnight window, before end on the start day",
+			days:      []string{"fri"},
+			startTime: "22:00",
+			endTime:   "06:00",
+			timeZone:  "UTC",
+			input:     time.Date(2020, time.May, 15, 05, 00, 00, 00, time.UTC), // Friday
+			expect:    false,
+		},
+		{
+			name:      "time zone",
+			days:      []string{"sun-sat"},
+			startTime: "01:00",
+			endTime:   "03:00",
+			timeZone:  "Asia/Taipei",
+			input:     time.Date(2020, time.May, 13, 18, 00, 00, 00, time.UTC), // 02:00 in Taipei
+			expect:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tw, err := New(tt.days, tt.startTime, tt.endTime, tt.timeZone)
+			if err != nil {
+				t.Fatalf("expected no error but error reported: %v", err)
+			}
+
+			got := tw.Contains(tt.input)
+			if got != tt.expect {
+				t.Errorf("got %t is not equals to expected %t for %s", got, tt.expect, tw)
+			}
+		})
+	}
+}
+
+func TestParseWeekdays(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []string
+		expect  string
+		wantErr bool
+	}{
+		{
+			name:   "names and numbers",
+			input:  []string{"mon", "Wednesday", "5"},
+			expect: "---Mon---Wed---Fri---",
+		},
+		{
+			name:   "range wraps over the week",
+			input:  []string{"fri-mon"},
+			expect: "SunMon---------FriSat",
+		},
+		{
+			name:    "invalid day",
+			input:   []string{"someday"},
+			wantErr: true,
+		},
+		{
+			name:    "no day",
+			input:   []string{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWeekdays(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected an error but no error reported")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but error reported: %v", err)
+			}
+			if got.String() != tt.expect {
+				t.Errorf("got %s is not equals to expected %s", got, tt.expect)
+			}
+		})
+	}
+}